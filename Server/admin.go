@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//go:embed admin.html
+var adminDashboard []byte
+
+// Operator access to the lobbies. Every API request must carry the admin token as a bearer token
+type AdminServer struct {
+	lobbies *LobbyManager
+	token   []byte
+}
+
+func NewAdminServer(lobbies *LobbyManager, token string) *AdminServer {
+	return &AdminServer{
+		lobbies: lobbies,
+		token:   []byte(token),
+	}
+}
+
+// An error with the HTTP status it should be sent with
+type adminError struct {
+	status  int
+	message string
+}
+
+func (e *adminError) Error() string {
+	return e.message
+}
+
+var (
+	errAdminNotFound = &adminError{http.StatusNotFound, "not found"}
+	errAdminNoLobby  = &adminError{http.StatusNotFound, "lobby does not exist"}
+	errAdminNoClient = &adminError{http.StatusNotFound, "client is not in the lobby"}
+	errAdminNoGame   = &adminError{http.StatusConflict, "no game is being played"}
+)
+
+type AdminLobby struct {
+	ID      string        `json:"id"`
+	Game    *string       `json:"game"`
+	Playing bool          `json:"playing"`
+	Frozen  bool          `json:"frozen"`
+	Paused  bool          `json:"paused"`
+	Players []LobbyClient `json:"players"`
+}
+
+type AdminLobbyState struct {
+	*LobbySnapshot
+	Full interface{} `json:"full,omitempty"` // Everything the game knows, including what players can't see
+}
+
+type AdminGame struct {
+	Name       string `json:"name"`
+	MinPlayers int    `json:"min_players"`
+	MaxPlayers int    `json:"max_players"`
+	Enabled    bool   `json:"enabled"`
+}
+
+func (s *AdminServer) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), s.token) == 1
+}
+
+func (s *AdminServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(adminDashboard)
+}
+
+// Routes /admin/api/... requests. Lobby IDs are chosen by players and may contain anything,
+// so they are given in the id query parameter rather than the path
+func (s *AdminServer) handleAPI(w http.ResponseWriter, r *http.Request) {
+	defer logRecover("handleAPI")
+
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAdminError(w, &adminError{http.StatusUnauthorized, "invalid admin token"})
+		return
+	}
+
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/api"), "/"), "/")
+	id := r.URL.Query().Get("id")
+
+	var res interface{}
+	var err error
+	switch {
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "lobbies":
+		res = s.listLobbies()
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "lobby":
+		res, err = s.inspectLobby(id)
+	case r.Method == http.MethodPost && len(path) == 2 && path[0] == "lobby" && path[1] == "end":
+		err = s.endGame(id)
+	case r.Method == http.MethodPost && len(path) == 2 && path[0] == "lobby" && path[1] == "pause":
+		var body struct {
+			Paused bool `json:"paused"`
+		}
+		if err = decodeAdminBody(w, r, &body); err == nil {
+			err = s.pause(id, body.Paused)
+		}
+	case r.Method == http.MethodPost && len(path) == 2 && path[0] == "lobby" && path[1] == "kick":
+		var body struct {
+			ID string `json:"id"`
+		}
+		if err = decodeAdminBody(w, r, &body); err == nil {
+			err = s.kick(id, body.ID)
+		}
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "announce":
+		var body struct {
+			Message string `json:"message"`
+			Lobby   string `json:"lobby"` // Every lobby if empty
+		}
+		if err = decodeAdminBody(w, r, &body); err == nil {
+			err = s.announce(body.Lobby, body.Message)
+		}
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "games":
+		res = s.listGames()
+	case r.Method == http.MethodPost && len(path) == 2 && path[0] == "games":
+		var body struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err = decodeAdminBody(w, r, &body); err == nil {
+			err = s.toggleGame(path[1], body.Enabled)
+		}
+	default:
+		err = errAdminNotFound
+	}
+
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		slog.Info("admin action", "remote", r.RemoteAddr, "path", r.URL.Path, "lobby", id)
+	}
+
+	if res == nil {
+		res = map[string]bool{"ok": true}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func decodeAdminBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(v); err != nil {
+		return &adminError{http.StatusBadRequest, "invalid body: " + err.Error()}
+	}
+
+	return nil
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var ae *adminError
+	if errors.As(err, &ae) {
+		status = ae.status
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func (s *AdminServer) listLobbies() []*AdminLobby {
+	lobbies := []*AdminLobby{}
+	s.lobbies.Lobbies.Range(func(_, entry interface{}) bool {
+		lobby := entry.(*Lobby)
+		al := &AdminLobby{ID: lobby.ID}
+		err := lobby.call(func() error {
+			al.Game = lobby.Game
+			al.Playing = lobby.game != nil
+			al.Frozen = lobby.Frozen
+			al.Paused = lobby.Paused
+			al.Players = []LobbyClient{}
+			// Copied so they can be encoded off the lobby's goroutine
+			for _, c := range lobby.Clients {
+				al.Players = append(al.Players, *c)
+			}
+
+			return nil
+		})
+		// Deleted since the range started
+		if err != nil {
+			return true
+		}
+
+		sort.Slice(al.Players, func(i, j int) bool { return al.Players[i].JoinedAt < al.Players[j].JoinedAt })
+		lobbies = append(lobbies, al)
+		return true
+	})
+
+	sort.Slice(lobbies, func(i, j int) bool { return lobbies[i].ID < lobbies[j].ID })
+	return lobbies
+}
+
+func (s *AdminServer) inspectLobby(id string) (json.RawMessage, error) {
+	entry, ok := s.lobbies.Lobbies.Load(id)
+	if !ok {
+		return nil, errAdminNoLobby
+	}
+
+	lobby := entry.(*Lobby)
+	var data json.RawMessage
+	err := lobby.call(func() error {
+		state := &AdminLobbyState{LobbySnapshot: lobby.Snapshot()}
+		if g, ok := lobby.game.(InspectableGame); ok {
+			state.Full = g.Inspect()
+		}
+
+		var err error
+		data, err = json.Marshal(state)
+		return err
+	})
+	if err == errLobbyGone {
+		return nil, errAdminNoLobby
+	}
+
+	return data, err
+}
+
+// Runs f on the lobby's goroutine, like a move
+func (s *AdminServer) withLobby(id string, f func(lobby *Lobby) error) error {
+	s.lobbies.moves.RLock()
+	defer s.lobbies.moves.RUnlock()
+
+	entry, ok := s.lobbies.Lobbies.Load(id)
+	if !ok {
+		return errAdminNoLobby
+	}
+
+	lobby := entry.(*Lobby)
+	err := lobby.call(func() error { return f(lobby) })
+	if err == errLobbyGone {
+		return errAdminNoLobby
+	}
+
+	return err
+}
+
+func (s *AdminServer) endGame(id string) error {
+	return s.withLobby(id, func(lobby *Lobby) error {
+		if lobby.game == nil {
+			return errAdminNoGame
+		}
+
+		s.lobbies.endGame(lobby)
+		return nil
+	})
+}
+
+// Holds the game until the admin resumes it. Players reconnecting or the game ending don't
+// resume it, and resuming doesn't unfreeze a game that is waiting for disconnected players
+func (s *AdminServer) pause(id string, paused bool) error {
+	return s.withLobby(id, func(lobby *Lobby) error {
+		if paused && lobby.game == nil {
+			return errAdminNoGame
+		}
+
+		lobby.Paused = paused
+		if paused {
+			lobby.Announce("The game has been paused by an admin")
+		} else {
+			lobby.Announce("The game has been resumed by an admin")
+		}
+		lobby.Sync()
+		return nil
+	})
+}
+
+// Games can't go on without one of their players, so kicking a player ends the game first
+func (s *AdminServer) kick(id string, clientID string) error {
+	return s.withLobby(id, func(lobby *Lobby) error {
+		target, ok := lobby.Clients[clientID]
+		if !ok {
+			return errAdminNoClient
+		}
+
+		if lobby.game != nil {
+			s.lobbies.endGame(lobby)
+			// Disconnected players are dropped when the game ends
+			if lobby.Clients[clientID] != target {
+				return nil
+			}
+		}
+
+		s.lobbies.kick(lobby, target)
+		return nil
+	})
+}
+
+func (s *AdminServer) announce(id string, message string) error {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return &adminError{http.StatusBadRequest, "message must not be empty"}
+	}
+
+	if id != "" {
+		entry, ok := s.lobbies.Lobbies.Load(id)
+		if !ok {
+			return errAdminNoLobby
+		}
+
+		entry.(*Lobby).chat.sendSystemMessage(message)
+		return nil
+	}
+
+	s.lobbies.Lobbies.Range(func(_, entry interface{}) bool {
+		entry.(*Lobby).chat.sendSystemMessage(message)
+		return true
+	})
+
+	return nil
+}
+
+func (s *AdminServer) listGames() []*AdminGame {
+	games := []*AdminGame{}
+	for _, g := range GAMES {
+		games = append(games, &AdminGame{
+			Name:       g.Name,
+			MinPlayers: g.MinPlayers,
+			MaxPlayers: g.MaxPlayers,
+			Enabled:    gameEnabled(g.Name),
+		})
+	}
+
+	sort.Slice(games, func(i, j int) bool { return games[i].Name < games[j].Name })
+	return games
+}
+
+func (s *AdminServer) toggleGame(name string, enabled bool) error {
+	if _, ok := GAMES[name]; !ok {
+		return &adminError{http.StatusNotFound, "game does not exist"}
+	}
+
+	setGameEnabled(name, enabled)
+
+	// Leaders in lobbies are told which games they can select
+	s.lobbies.Lobbies.Range(func(_, entry interface{}) bool {
+		lobby := entry.(*Lobby)
+		lobby.post(func() {
+			if lobby.game == nil {
+				lobby.Sync()
+			}
+		})
+
+		return true
+	})
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// How long a chat/voice key stays valid. Clients can get a new one with MoveRefreshKey
+const keyLifetime = time.Hour
+
+// Claims of the key handed to lobby clients for connecting to /chat and /voice
+type LobbyClaims struct {
+	ClientID string `json:"client_id"`
+	LobbyID  string `json:"lobby_id"`
+	jwt.RegisteredClaims
+}
+
+func issueKey(lobbyID string, clientID string) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &LobbyClaims{
+		ClientID: clientID,
+		LobbyID:  lobbyID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(keyLifetime)),
+		},
+	})
+
+	return token.SignedString(sharedKey)
+}
+
+// Parses and validates a key, including its expiry
+func parseKey(key string) (*LobbyClaims, error) {
+	claims := &LobbyClaims{}
+	_, err := jwt.ParseWithClaims(key, claims, func(token *jwt.Token) (interface{}, error) {
+		return sharedKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired(), jwt.WithIssuedAt())
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// Whether the client the key was issued to is still part of the lobby
+func (lm *LobbyManager) IsMember(lobbyID string, clientID string) bool {
+	entry, ok := lm.Lobbies.Load(lobbyID)
+	if !ok {
+		return false
+	}
+
+	_, ok = entry.(*Lobby).members.Load(clientID)
+	return ok
+}
+
+// Closes any chat and voice connections opened with the client's keys
+func revokeKeys(lobby *Lobby, clientID string) {
+	lobby.chat.kick(clientID, ChatErrorRevoked, "you are no longer in this lobby")
+	lobby.voice.disconnect(clientID)
+}
+
+// Returns the IDs a key would be issued with for the client, if they are in a lobby.
+// Must not be called from a lobby's goroutine
+func (lm *LobbyManager) Member(client *Client) (lobbyID string, clientID string, ok bool) {
+	lobby := lm.Lobby(client)
+	if lobby == nil {
+		return "", "", false
+	}
+
+	lobby.call(func() error {
+		if lc := lobby.Client(client); lc != nil {
+			clientID, ok = lc.ID, true
+		}
+
+		return nil
+	})
+
+	return lobby.ID, clientID, ok
+}
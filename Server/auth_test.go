@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// Records what the server sent and whether it closed the connection
+type recordChannel struct {
+	mu     sync.Mutex
+	sent   []string
+	closed bool
+}
+
+func (c *recordChannel) Send(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, string(data))
+	return nil
+}
+
+func (c *recordChannel) messages() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.sent...)
+}
+
+func (c *recordChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *recordChannel) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Has a leader and a second client join the lobby. Returns the leader and the ID of the second client
+func joinTwo(t *testing.T, lm *LobbyManager, server *GameServer, lobbyID string) (*Client, string) {
+	t.Helper()
+
+	leader := server.newClient(nopChannel{}, "10.0.0.1")
+	other := server.newClient(nopChannel{}, "10.0.0.2")
+	for _, c := range []*Client{leader, other} {
+		c.Sync()
+		if err := lm.ExecuteMoves(c, []string{MoveJoin}, map[string]interface{}{"lobby": lobbyID, "name": "Player"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, clientID, ok := lm.Member(other)
+	if !ok {
+		t.Fatal("second client is not in the lobby")
+	}
+
+	return leader, clientID
+}
+
+// A kick racing a chat or voice connection being opened must never leave the connection open
+func TestKickRacesOpen(t *testing.T) {
+	lm, server := newTestLobbyManager(t)
+	chat := NewChatServer(lm, NewWordFilter(nil))
+	voice := NewVoiceServer(lm)
+
+	for i := 0; i < 50; i++ {
+		lobbyID := fmt.Sprintf("race-%d", i)
+		leader, clientID := joinTwo(t, lm, server, lobbyID)
+
+		chatConn, voiceConn := &recordChannel{}, &recordChannel{}
+		var cs *chatSession
+		var vs *voiceSession
+
+		// Released together so none of them gets a head start
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			<-start
+			cs = chat.open(chatConn, lobbyID, clientID)
+		}()
+		go func() {
+			defer wg.Done()
+			<-start
+			vs = voice.open(voiceConn, lobbyID, clientID)
+		}()
+		go func() {
+			defer wg.Done()
+			<-start
+			// Vary how far the opens get before the kick
+			for j := 0; j < i%8; j++ {
+				runtime.Gosched()
+			}
+
+			if err := lm.ExecuteMoves(leader, []string{MoveKick}, map[string]interface{}{"id": clientID}); err != nil {
+				t.Error(err)
+			}
+		}()
+		close(start)
+		wg.Wait()
+
+		if cs != nil && !chatConn.isClosed() {
+			t.Errorf("%s: chat stayed open after the kick", lobbyID)
+		}
+
+		if vs != nil && !voiceConn.isClosed() {
+			t.Errorf("%s: voice stayed open after the kick", lobbyID)
+		}
+
+		// Opening after the kick is refused
+		if chat.open(&recordChannel{}, lobbyID, clientID) != nil {
+			t.Errorf("%s: chat opened after the kick", lobbyID)
+		}
+
+		if voice.open(&recordChannel{}, lobbyID, clientID) != nil {
+			t.Errorf("%s: voice opened after the kick", lobbyID)
+		}
+
+		leader.Disconnect()
+	}
+}
+
+// Kicked clients can't send anything on a session that was already open, nor open one once the lobby is gone
+func TestKickClosesOpenSessions(t *testing.T) {
+	lm, server := newTestLobbyManager(t)
+	chat := NewChatServer(lm, NewWordFilter(nil))
+	voice := NewVoiceServer(lm)
+
+	leader, clientID := joinTwo(t, lm, server, "kick")
+	chatConn, voiceConn := &recordChannel{}, &recordChannel{}
+	cs := chat.open(chatConn, "kick", clientID)
+	vs := voice.open(voiceConn, "kick", clientID)
+	if cs == nil || vs == nil {
+		t.Fatal("couldn't open chat and voice")
+	}
+
+	if err := lm.ExecuteMoves(leader, []string{MoveKick}, map[string]interface{}{"id": clientID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !chatConn.isClosed() || !voiceConn.isClosed() {
+		t.Fatal("chat or voice stayed open")
+	}
+
+	if cs.handle([]byte("hello")) {
+		t.Error("chat message accepted after the kick")
+	}
+
+	if vs.handle([]byte(`{"type":"leave"}`)) {
+		t.Error("voice message accepted after the kick")
+	}
+
+	// The last human leaving deletes the lobby. Disconnects are posted, so the call waits for it
+	lobby := lm.Lobby(leader)
+	leader.Disconnect()
+	if err := lobby.call(func() error { return nil }); err != errLobbyGone {
+		t.Fatal("lobby wasn't deleted")
+	}
+
+	if chat.open(&recordChannel{}, "kick", clientID) != nil || voice.open(&recordChannel{}, "kick", clientID) != nil {
+		t.Error("chat or voice opened after the lobby was deleted")
+	}
+}
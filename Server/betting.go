@@ -0,0 +1,331 @@
+package main
+
+import (
+	"errors"
+	"sort"
+
+	"golang.org/x/exp/slices"
+)
+
+// Chips for wagering games. Betting keeps every player's stack across hands, what they have
+// bet this betting round and this hand, and splits it into pots and side pots. Games embed it,
+// which puts its fields in their state, and either let it run no-limit betting rounds with the
+// fold, check, call and raise moves or take wagers of their own with Wager
+
+const (
+	moveFold  = "fold"
+	moveCheck = "check"
+	moveCall  = "call"
+	moveRaise = "raise"
+)
+
+var errInvalidAmount = errors.New("amount must be a whole number of chips")
+
+type Pot struct {
+	Amount  int      `json:"amount"`
+	Players []string `json:"players"` // Who can win it
+}
+
+type Betting struct {
+	Stacks     map[string]int  `json:"stacks"`
+	Bets       map[string]int  `json:"bets"`      // What each player has put in this betting round
+	Committed  map[string]int  `json:"committed"` // What each player has put in this hand
+	Folded     map[string]bool `json:"folded"`
+	CurrentBet int             `json:"current_bet"` // What everyone has to match to stay in
+	MinRaise   int             `json:"min_raise"`   // The smallest raise allowed on top of the current bet
+	Pots       []Pot           `json:"pots"`        // As of the end of the last betting round
+
+	seats   []string        // Everyone at the table, in order
+	playing map[string]bool // Who was dealt into the hand
+	acted   map[string]bool // Who has acted since the last full raise
+	minBet  int
+}
+
+// Seats the players with the same stack each
+func NewBetting(seats []string, stack int) *Betting {
+	b := &Betting{
+		Stacks: make(map[string]int),
+		seats:  seats,
+	}
+
+	for _, id := range seats {
+		b.Stacks[id] = stack
+	}
+
+	b.NewHand(0)
+	return b
+}
+
+// Clears the bets of the last hand and deals in everyone who has chips. minBet is the smallest
+// bet and raise, like the big blind
+func (b *Betting) NewHand(minBet int) {
+	b.Bets = make(map[string]int)
+	b.Committed = make(map[string]int)
+	b.Folded = make(map[string]bool)
+	b.Pots = []Pot{}
+	b.CurrentBet = 0
+	b.MinRaise = minBet
+	b.minBet = minBet
+	b.acted = make(map[string]bool)
+
+	b.playing = make(map[string]bool)
+	for _, id := range b.seats {
+		b.playing[id] = b.Stacks[id] > 0
+	}
+}
+
+// Whether the player was dealt in and hasn't folded
+func (b *Betting) InHand(player string) bool {
+	return b.playing[player] && !b.Folded[player]
+}
+
+// Whether the player is in the hand with nothing left to bet
+func (b *Betting) AllIn(player string) bool {
+	return b.InHand(player) && b.Stacks[player] == 0
+}
+
+// The players still in the hand, in seating order
+func (b *Betting) Remaining() []string {
+	remaining := []string{}
+	for _, id := range b.seats {
+		if b.InHand(id) {
+			remaining = append(remaining, id)
+		}
+	}
+
+	return remaining
+}
+
+// How many players in the hand can still bet
+func (b *Betting) CanBet() int {
+	n := 0
+	for _, id := range b.Remaining() {
+		if b.Stacks[id] > 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Moves chips from the player's stack into their bet, up to everything they have.
+// Returns how much was put in
+func (b *Betting) Put(player string, amount int) int {
+	if amount > b.Stacks[player] {
+		amount = b.Stacks[player]
+	}
+
+	b.Stacks[player] -= amount
+	b.Bets[player] += amount
+	b.Committed[player] += amount
+	return amount
+}
+
+// Posts a forced bet, which everyone else has to match even if the player can't cover it
+func (b *Betting) Blind(player string, amount int) {
+	b.Put(player, amount)
+	if amount > b.CurrentBet {
+		b.CurrentBet = amount
+	}
+}
+
+// Adds chips to the player's stack
+func (b *Betting) Pay(player string, amount int) {
+	b.Stacks[player] += amount
+}
+
+// What the player has to put in to call, up to everything they have
+func (b *Betting) ToCall(player string) int {
+	toCall := b.CurrentBet - b.Bets[player]
+	if toCall > b.Stacks[player] {
+		toCall = b.Stacks[player]
+	}
+
+	return toCall
+}
+
+// The bets the player could raise to, or 0, 0 if they can't raise. A raise must be at least
+// as big as the last one unless it puts the player all in
+func (b *Betting) RaiseRange(player string) (int, int) {
+	max := b.Bets[player] + b.Stacks[player]
+	if b.acted[player] || max <= b.CurrentBet {
+		return 0, 0
+	}
+
+	min := b.CurrentBet + b.MinRaise
+	if min > max {
+		min = max
+	}
+
+	return min, max
+}
+
+// Whether the player still has to act this betting round
+func (b *Betting) NeedsAction(player string) bool {
+	return b.InHand(player) && b.Stacks[player] > 0 && (!b.acted[player] || b.Bets[player] < b.CurrentBet)
+}
+
+// Reads a whole number of chips from a packet's data
+func BetAmount(data interface{}) (int, error) {
+	amount, ok := Get[float64](data, "amount")
+	if !ok || amount != float64(int(amount)) {
+		return 0, errInvalidAmount
+	}
+
+	return int(amount), nil
+}
+
+// The moves the player can make in a betting round, with how much a call costs and what they
+// may raise to as data
+func (b *Betting) Moves(player string) ([]string, map[string]interface{}) {
+	moves := []string{moveFold}
+	if b.ToCall(player) == 0 {
+		moves = append(moves, moveCheck)
+	} else {
+		moves = append(moves, moveCall)
+	}
+
+	min, max := b.RaiseRange(player)
+	if max > 0 {
+		moves = append(moves, moveRaise)
+	}
+
+	return moves, map[string]interface{}{
+		"call":      b.ToCall(player),
+		"min_raise": min,
+		"max_raise": max,
+	}
+}
+
+// Makes one of the betting round moves. A raise is sent with {"amount": n}, n being the total
+// the player's bet this round becomes
+func (b *Betting) Act(player string, move string, data interface{}) error {
+	switch move {
+	case moveFold:
+		b.Folded[player] = true
+	case moveCheck:
+		if b.ToCall(player) > 0 {
+			return errors.New("you cannot check")
+		}
+	case moveCall:
+		b.Put(player, b.ToCall(player))
+	case moveRaise:
+		to, err := BetAmount(data)
+		if err != nil {
+			return err
+		}
+
+		min, max := b.RaiseRange(player)
+		if max == 0 || to < min || to > max {
+			return errors.New("invalid raise")
+		}
+
+		// A raise smaller than the last one, which is only allowed all in, doesn't let
+		// players who already acted raise again
+		if to-b.CurrentBet >= b.MinRaise {
+			b.MinRaise = to - b.CurrentBet
+			b.acted = make(map[string]bool)
+		}
+		b.CurrentBet = to
+		b.Put(player, to-b.Bets[player])
+	default:
+		return errors.New("unknown move")
+	}
+
+	b.acted[player] = true
+	return nil
+}
+
+// Takes a bet between min and max from the packet's data, for games where players don't bet
+// against each other
+func (b *Betting) Wager(player string, data interface{}, min int, max int) (int, error) {
+	amount, err := BetAmount(data)
+	if err != nil {
+		return 0, err
+	}
+
+	if amount < min || amount > max {
+		return 0, errors.New("bet is out of range")
+	}
+
+	if amount > b.Stacks[player] {
+		return 0, errors.New("you don't have enough chips")
+	}
+
+	return b.Put(player, amount), nil
+}
+
+// Ends the betting round, gathering the bets into the pots
+func (b *Betting) EndRound() {
+	b.Pots = b.SidePots()
+	b.Bets = make(map[string]int)
+	b.acted = make(map[string]bool)
+	b.CurrentBet = 0
+	b.MinRaise = b.minBet
+}
+
+// Splits what everyone has committed this hand into the main pot and side pots
+func (b *Betting) SidePots() []Pot {
+	levels := []int{}
+	for id, c := range b.Committed {
+		if b.InHand(id) && !slices.Contains(levels, c) {
+			levels = append(levels, c)
+		}
+	}
+	sort.Ints(levels)
+
+	pots := []Pot{}
+	prev := 0
+	for i, level := range levels {
+		pot := Pot{Players: []string{}}
+		for _, id := range b.seats {
+			c := b.Committed[id]
+			// Chips of folded players above the highest level still go in the last pot
+			if c > level && i < len(levels)-1 {
+				c = level
+			}
+
+			if c > prev {
+				pot.Amount += c - prev
+			}
+
+			if b.InHand(id) && b.Committed[id] >= level {
+				pot.Players = append(pot.Players, id)
+			}
+		}
+
+		// A pot that the same players can win as the one before is part of it
+		if len(pots) > 0 && len(pots[len(pots)-1].Players) == len(pot.Players) {
+			pots[len(pots)-1].Amount += pot.Amount
+		} else if pot.Amount > 0 {
+			pots = append(pots, pot)
+		}
+
+		prev = level
+	}
+
+	return pots
+}
+
+// Gives each pot to the players winners picks out of it. Odd chips go to the first winners.
+// Returns what each player won
+func (b *Betting) Award(winners func(pot Pot) []string) map[string]int {
+	b.Pots = b.SidePots()
+	b.Bets = make(map[string]int)
+
+	won := make(map[string]int)
+	for _, pot := range b.Pots {
+		ws := winners(pot)
+		for i, id := range ws {
+			share := pot.Amount / len(ws)
+			if i < pot.Amount%len(ws) {
+				share++
+			}
+
+			won[id] += share
+			b.Pay(id, share)
+		}
+	}
+
+	return won
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Deals in players a to d with the given stacks, has them commit the given chips and folds
+// the folded ones
+func newTestBetting(stacks map[string]int, committed map[string]int, folded ...string) *Betting {
+	b := NewBetting([]string{"a", "b", "c", "d"}, 0)
+	for id, stack := range stacks {
+		b.Stacks[id] = stack
+	}
+
+	b.NewHand(0)
+	for id, amount := range committed {
+		b.Put(id, amount)
+	}
+
+	for _, id := range folded {
+		b.Folded[id] = true
+	}
+
+	return b
+}
+
+func TestSidePots(t *testing.T) {
+	tests := []struct {
+		name      string
+		stacks    map[string]int
+		committed map[string]int
+		folded    []string
+		want      []Pot
+	}{
+		{
+			name:      "no all ins",
+			stacks:    map[string]int{"a": 100, "b": 100, "c": 100},
+			committed: map[string]int{"a": 20, "b": 20, "c": 20},
+			want:      []Pot{{60, []string{"a", "b", "c"}}},
+		},
+		{
+			name:      "three all ins",
+			stacks:    map[string]int{"a": 10, "b": 20, "c": 50, "d": 100},
+			committed: map[string]int{"a": 10, "b": 20, "c": 50, "d": 50},
+			want: []Pot{
+				{40, []string{"a", "b", "c", "d"}},
+				{30, []string{"b", "c", "d"}},
+				{60, []string{"c", "d"}},
+			},
+		},
+		{
+			name:      "all ins of the same size",
+			stacks:    map[string]int{"a": 10, "b": 10, "c": 30, "d": 100},
+			committed: map[string]int{"a": 10, "b": 10, "c": 30, "d": 30},
+			want: []Pot{
+				{40, []string{"a", "b", "c", "d"}},
+				{40, []string{"c", "d"}},
+			},
+		},
+		{
+			name:      "folded chips between levels",
+			stacks:    map[string]int{"a": 10, "b": 30, "c": 100, "d": 100},
+			committed: map[string]int{"a": 10, "b": 30, "c": 25, "d": 30},
+			folded:    []string{"c"},
+			want: []Pot{
+				{40, []string{"a", "b", "d"}},
+				{55, []string{"b", "d"}},
+			},
+		},
+		{
+			name:      "folded chips above every level",
+			stacks:    map[string]int{"a": 10, "b": 100, "c": 100},
+			committed: map[string]int{"a": 10, "b": 40, "c": 10},
+			folded:    []string{"b"},
+			want:      []Pot{{60, []string{"a", "c"}}},
+		},
+		{
+			name:      "only the all in player is left",
+			stacks:    map[string]int{"a": 10, "b": 100, "c": 100},
+			committed: map[string]int{"a": 10, "b": 30, "c": 30},
+			folded:    []string{"b", "c"},
+			want:      []Pot{{70, []string{"a"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBetting(tt.stacks, tt.committed, tt.folded...)
+			if got := b.SidePots(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SidePots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAward(t *testing.T) {
+	tests := []struct {
+		name      string
+		stacks    map[string]int
+		committed map[string]int
+		folded    []string
+		winners   map[int][]string // By pot
+		want      map[string]int
+	}{
+		{
+			name:      "single winner",
+			stacks:    map[string]int{"a": 100, "b": 100, "c": 100},
+			committed: map[string]int{"a": 20, "b": 20, "c": 20},
+			winners:   map[int][]string{0: {"b"}},
+			want:      map[string]int{"b": 60},
+		},
+		{
+			name:      "odd chip goes to the first winner",
+			stacks:    map[string]int{"a": 100, "b": 100, "c": 100},
+			committed: map[string]int{"a": 5, "b": 10, "c": 10},
+			folded:    []string{"a"},
+			winners:   map[int][]string{0: {"b", "c"}},
+			want:      map[string]int{"b": 13, "c": 12},
+		},
+		{
+			name:      "two odd chips in a three way split",
+			stacks:    map[string]int{"a": 100, "b": 100, "c": 100, "d": 100},
+			committed: map[string]int{"a": 10, "b": 10, "c": 10, "d": 2},
+			folded:    []string{"d"},
+			winners:   map[int][]string{0: {"a", "b", "c"}},
+			want:      map[string]int{"a": 11, "b": 11, "c": 10},
+		},
+		{
+			name:      "side pots with three all ins",
+			stacks:    map[string]int{"a": 10, "b": 20, "c": 50, "d": 100},
+			committed: map[string]int{"a": 10, "b": 20, "c": 50, "d": 50},
+			winners: map[int][]string{
+				0: {"a", "b", "c"},
+				1: {"b", "c"},
+				2: {"d"},
+			},
+			// 40 split three ways, 30 split two ways and 60 to d
+			want: map[string]int{"a": 14, "b": 13 + 15, "c": 13 + 15, "d": 60},
+		},
+		{
+			name:      "short all in wins only the main pot",
+			stacks:    map[string]int{"a": 7, "b": 100, "c": 100},
+			committed: map[string]int{"a": 7, "b": 30, "c": 30},
+			winners: map[int][]string{
+				0: {"a"},
+				1: {"b", "c"},
+			},
+			want: map[string]int{"a": 21, "b": 23, "c": 23},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBetting(tt.stacks, tt.committed, tt.folded...)
+			before := make(map[string]int)
+			for id, stack := range b.Stacks {
+				before[id] = stack
+			}
+
+			pot := 0
+			got := b.Award(func(p Pot) []string {
+				ws := tt.winners[pot]
+				pot++
+				return ws
+			})
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Award() = %v, want %v", got, tt.want)
+			}
+
+			// Every chip committed is paid out
+			total := 0
+			for _, amount := range tt.committed {
+				total += amount
+			}
+			for id, amount := range got {
+				total -= amount
+				if b.Stacks[id] != before[id]+amount {
+					t.Errorf("%s has %d chips, want %d", id, b.Stacks[id], before[id]+amount)
+				}
+			}
+			if total != 0 {
+				t.Errorf("%d chips were not paid out", total)
+			}
+		})
+	}
+}
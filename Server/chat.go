@@ -1,128 +1,420 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+// The number of messages each lobby keeps around to replay to new connections
+const chatHistoryLength = 100
+
+// The maximum number of characters a single chat message may contain
+const chatMaxMessageLength = 500
+
+// The maximum size of a single frame read from a chat connection. Anything
+// larger closes the connection
+const chatReadLimit = 4096
+
+// Each client may send a burst of chatRateBurst messages, after which they
+// are limited to chatRateLimit messages per second
+const (
+	chatRateLimit = 1
+	chatRateBurst = 5
+)
+
+type ChatKind string
+
+const (
+	ChatKindUser   ChatKind = "user"
+	ChatKindSystem ChatKind = "system"
+	ChatKindError  ChatKind = "error"
+)
+
+// Codes sent along with error messages so the client knows what went wrong
+const (
+	ChatErrorRateLimited = "rate_limited"
+	ChatErrorTooLong     = "too_long"
+	ChatErrorLobbyClosed = "lobby_closed"
+	ChatErrorRevoked     = "revoked"
+	ChatErrorExpired     = "expired"
+)
+
+type ChatMessage struct {
+	ID        string   `json:"id"`
+	Sender    string   `json:"sender,omitempty"` // ID of the lobby client, empty for system messages
+	Timestamp int64    `json:"timestamp"`
+	Kind      ChatKind `json:"kind"`
+	Content   string   `json:"content"`
+	Code      string   `json:"code,omitempty"` // Only set for error messages
+}
+
+type ChatLobby struct {
+	id          string
+	bus         PubSub
+	mu          sync.Mutex // Protect conns, history, limiters and unsubscribe
+	conns       map[string]Channel
+	history     []*ChatMessage
+	limiters    map[string]*TokenBucket // Kept across reconnects so they can't be used to reset the limit
+	unsubscribe func()                  // Stops delivering messages published to the lobby
+}
+
+// A chat message on its way to the connections of a lobby, which may be held by any node
+type chatDelivery struct {
+	Message *ChatMessage `json:"message"`
+	To      string       `json:"to,omitempty"`    // Only this client, without recording it in the history
+	Close   bool         `json:"close,omitempty"` // Close the recipient's connection once the message is sent
+}
+
+type ChatServer struct {
+	lobbies *LobbyManager
+	filter  *WordFilter
+}
+
+func NewChatServer(lobbies *LobbyManager, filter *WordFilter) *ChatServer {
+	return &ChatServer{
+		lobbies: lobbies,
+		filter:  filter,
+	}
+}
+
 var chatUpgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+	CheckOrigin:     checkOrigin,
 }
 
-// map of chatLobbies to clients to connections
-// var chatLobbies = map[string]map[string]*websocket.Conn{}
-var chatLobbies sync.Map
+// Every lobby has its own chat, which lives and dies with it
+func newChatLobby(lobbyID string, bus PubSub) *ChatLobby {
+	return &ChatLobby{
+		id:       lobbyID,
+		bus:      bus,
+		conns:    make(map[string]Channel),
+		limiters: make(map[string]*TokenBucket),
+	}
+}
 
-func handleChatClose(lobbyID string, clientID string) {
-	if lobbyAny, ok := chatLobbies.Load(lobbyID); ok {
-		lobby := lobbyAny.(*sync.Map)
-		if clientAny, ok := lobby.Load(clientID); ok {
-			client := clientAny.(*websocket.Conn)
-			client.Close()
-			lobby.Delete(clientID)
-		}
+func chatTopic(lobbyID string) string {
+	return "chat." + lobbyID
+}
+
+// Starts delivering messages published to the lobby to the connections held here
+func (cl *ChatLobby) subscribe() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.unsubscribe = cl.bus.Subscribe(chatTopic(cl.id), cl.deliver)
+}
 
-		// Remove empty lobbies from map
-		empty := true
-		lobby.Range(func(_, _ interface{}) bool {
-			empty = false
-			return false
-		})
+func (cl *ChatLobby) publish(d *chatDelivery) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		slog.Error("marshal chat message", "err", err)
+		return
+	}
+
+	if err := cl.bus.Publish(chatTopic(cl.id), data); err != nil {
+		slog.Error("publish chat message", "lobby", cl.id, "err", err)
+	}
+}
+
+// Publishes the message to every node with connections to the lobby's chat
+func (cl *ChatLobby) broadcast(m *ChatMessage) {
+	cl.publish(&chatDelivery{Message: m})
+}
 
-		if empty {
-			chatLobbies.Delete(lobbyID)
+// Sends a published message to its recipients connected to this node
+func (cl *ChatLobby) deliver(data []byte) {
+	d := &chatDelivery{}
+	if err := json.Unmarshal(data, d); err != nil || d.Message == nil {
+		slog.Error("unmarshal chat message", "lobby", cl.id, "err", err)
+		return
+	}
+
+	message, err := json.Marshal(d.Message)
+	if err != nil {
+		slog.Error("marshal chat message", "err", err)
+		return
+	}
+
+	if d.To != "" {
+		cl.mu.Lock()
+		conn, ok := cl.conns[d.To]
+		if ok {
+			conn.Send(message)
+		}
+		cl.mu.Unlock()
+
+		if ok && d.Close {
+			cl.leave(d.To, conn)
 		}
+		return
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.history = append(cl.history, d.Message)
+	if len(cl.history) > chatHistoryLength {
+		cl.history = cl.history[len(cl.history)-chatHistoryLength:]
+	}
+
+	for _, conn := range cl.conns {
+		conn.Send(message)
 	}
 }
 
-func handleChatWs(w http.ResponseWriter, r *http.Request) {
-	defer func() {
-		// Just to make sure the server won't crash
-		if r := recover(); r != nil {
-			log.Println("recovered error in handleWs")
-			if err, ok := r.(error); ok {
-				log.Println(err.Error())
-			} else {
-				log.Println(r)
-			}
+// Registers the connection for the client and replays the lobby's history to it
+func (cl *ChatLobby) join(clientID string, conn Channel) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	// If a user joins more than once, remove the previous instance
+	if prev, ok := cl.conns[clientID]; ok {
+		prev.Close()
+	}
+
+	cl.conns[clientID] = conn
+	conn.Send([]byte("OK"))
+
+	for _, m := range cl.history {
+		data, err := json.Marshal(m)
+		if err != nil {
+			continue
 		}
-	}()
 
-	var lobbyID, clientID string
+		conn.Send(data)
+	}
+}
 
-	conn, err := chatUpgrader.Upgrade(w, r, nil)
+// Returns whether the client is allowed to send another message right now
+func (cl *ChatLobby) allow(clientID string) bool {
+	cl.mu.Lock()
+	limiter, ok := cl.limiters[clientID]
+	if !ok {
+		limiter = NewTokenBucket(chatRateLimit, chatRateBurst)
+		cl.limiters[clientID] = limiter
+	}
+	cl.mu.Unlock()
+
+	return limiter.Allow()
+}
+
+// Sends an error to a single connection without recording it in the history
+func (cl *ChatLobby) sendError(conn Channel, code string, message string) {
+	m := newChatMessage(ChatKindError, "", message)
+	m.Code = code
+
+	data, err := json.Marshal(m)
 	if err != nil {
-		log.Println("Upgrade:", err)
+		slog.Error("marshal chat message", "err", err)
 		return
 	}
 
-	conn.SetCloseHandler(func(code int, text string) error {
-		handleChatClose(lobbyID, clientID)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	conn.Send(data)
+}
+
+// Sends an error to the client's connection, if any, and closes it, on whichever node holds it
+func (cl *ChatLobby) kick(clientID string, code string, message string) {
+	m := newChatMessage(ChatKindError, "", message)
+	m.Code = code
+	cl.publish(&chatDelivery{Message: m, To: clientID, Close: true})
+}
+
+func (cl *ChatLobby) leave(clientID string, conn Channel) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	// The connection may have already been replaced by a newer one
+	if cl.conns[clientID] == conn {
+		delete(cl.conns, clientID)
+	}
+	conn.Close()
+}
+
+func newChatMessage(kind ChatKind, sender string, content string) *ChatMessage {
+	return &ChatMessage{
+		ID:        uuid.NewString(),
+		Sender:    sender,
+		Timestamp: time.Now().UnixMilli(),
+		Kind:      kind,
+		Content:   content,
+	}
+}
+
+// Sends a message from the server to everyone in the lobby's chat
+func (cl *ChatLobby) sendSystemMessage(content string) {
+	cl.broadcast(newChatMessage(ChatKindSystem, "", content))
+}
+
+// Stops delivering messages to the lobby and closes every chat connection held here
+func (cl *ChatLobby) close() {
+	cl.mu.Lock()
+	conns := cl.conns
+	cl.conns = make(map[string]Channel)
+	if cl.unsubscribe != nil {
+		cl.unsubscribe()
+		cl.unsubscribe = nil
+	}
+	cl.mu.Unlock()
+
+	for _, conn := range conns {
+		cl.sendError(conn, ChatErrorLobbyClosed, "the lobby has been closed")
+		conn.Close()
+	}
+}
+
+// A client's connection to their lobby's chat
+type chatSession struct {
+	server   *ChatServer
+	lobby    *ChatLobby
+	conn     Channel
+	lobbyID  string
+	clientID string
+}
+
+// Attaches an authenticated connection to the lobby's chat and replays its history. Returns
+// nil if the client is no longer in the lobby. The connection is registered on the lobby's
+// goroutine, where clients are removed and lobbies deleted, so it can't slip past revokeKeys
+// or the chat being closed
+func (s *ChatServer) open(conn Channel, lobbyID string, clientID string) *chatSession {
+	entry, ok := s.lobbies.Lobbies.Load(lobbyID)
+	if !ok {
+		return nil
+	}
+
+	lobby := entry.(*Lobby)
+	err := lobby.call(func() error {
+		if _, ok := lobby.Clients[clientID]; !ok {
+			return errors.New("client is not in the lobby")
+		}
+
+		lobby.chat.join(clientID, conn)
 		return nil
 	})
+	if err != nil {
+		return nil
+	}
 
-	messageType, msg, err := conn.ReadMessage()
+	return &chatSession{
+		server:   s,
+		lobby:    lobby.chat,
+		conn:     conn,
+		lobbyID:  lobbyID,
+		clientID: clientID,
+	}
+}
+
+func (cs *chatSession) close() {
+	cs.lobby.leave(cs.clientID, cs.conn)
+}
+
+// Handles a single message sent by the client. Returns false once the session has been closed
+func (cs *chatSession) handle(msg []byte) bool {
+	// Drop messages from clients who are no longer in the lobby, or whose lobby is gone
+	if !cs.server.lobbies.IsMember(cs.lobbyID, cs.clientID) {
+		cs.lobby.sendError(cs.conn, ChatErrorRevoked, "you are no longer in this lobby")
+		cs.close()
+		return false
+	}
+
+	content := strings.TrimSpace(string(msg))
+	if content == "" {
+		return true
+	}
+
+	if utf8.RuneCountInString(content) > chatMaxMessageLength {
+		cs.lobby.sendError(cs.conn, ChatErrorTooLong, fmt.Sprintf("messages cannot be longer than %d characters", chatMaxMessageLength))
+		return true
+	}
+
+	if !cs.lobby.allow(cs.clientID) {
+		cs.lobby.sendError(cs.conn, ChatErrorRateLimited, "you are sending messages too quickly")
+		return true
+	}
+
+	content = cs.server.filter.Mask(content)
+	cs.lobby.broadcast(newChatMessage(ChatKindUser, cs.clientID, content))
+	slog.Info("chat message", "lobby", cs.lobbyID, "client", cs.clientID, "content", redact(content))
+	return true
+}
+
+func (s *ChatServer) handleChatWs(w http.ResponseWriter, r *http.Request) {
+	defer logRecover("handleChatWs")
+
+	ip, ok := acquireConn(w, r)
+	if !ok {
+		return
+	}
+	defer connLimiter.Release(ip)
+
+	conn, err := chatUpgrader.Upgrade(w, r, nil)
 	if err != nil {
+		slog.Warn("upgrade failed", "socket", "chat", "remote", r.RemoteAddr, "err", err)
 		return
 	}
+	defer conn.Close()
+	conn.SetReadLimit(chatReadLimit)
 
-	if messageType == websocket.TextMessage {
-		token, err := jwt.Parse(string(msg), func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
+	// Chat may be quiet for a long time, so the connection is only closed if it stops answering pings
+	keepAlive := startKeepAlive(conn, 0)
+	defer keepAlive.stop()
 
-			return sharedKey, nil
-		})
-		if err != nil {
-			log.Println(err)
-			return
-		}
+	metricConnectedClients.WithLabelValues("chat").Inc()
+	defer metricConnectedClients.WithLabelValues("chat").Dec()
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			clientID = claims["client_id"].(string)
-			lobbyID = claims["lobby_id"].(string)
-		} else {
-			log.Println(err)
-			return
-		}
+	messageType, msg, err := conn.ReadMessage()
+	if err != nil {
+		return
 	}
 
-	conn.WriteMessage(websocket.TextMessage, []byte("OK"))
+	if messageType != websocket.TextMessage {
+		return
+	}
 
-	lobbyAny, _ := chatLobbies.LoadOrStore(lobbyID, &sync.Map{})
-	lobby := lobbyAny.(*sync.Map)
+	claims, err := parseKey(string(msg))
+	if err != nil {
+		slog.Info("invalid key", "socket", "chat", "remote", r.RemoteAddr, "err", err)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "invalid key"))
+		return
+	}
 
-	// If a user joins more than once, remove the previous instance
-	if _, ok := lobby.Load(clientID); ok {
-		handleChatClose(lobbyID, clientID)
+	session := s.open(newWsChannel(conn), claims.LobbyID, claims.ClientID)
+	if session == nil {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "you are no longer in this lobby"))
+		return
 	}
 
-	lobby.Store(clientID, conn)
+	// Close the connection once the key expires. The client can reconnect with a refreshed key
+	expiry := time.AfterFunc(time.Until(claims.ExpiresAt.Time), func() {
+		session.lobby.kick(session.clientID, ChatErrorExpired, "your key has expired")
+	})
+	defer expiry.Stop()
+
+	conn.SetCloseHandler(func(code int, text string) error {
+		session.close()
+		return nil
+	})
 
 	for {
 		messageType, msg, err := conn.ReadMessage()
 		if err != nil {
-			handleChatClose(lobbyID, clientID)
+			session.close()
 			return
 		}
 
-		if messageType == websocket.TextMessage {
-			lobby.Range(func(_, clientAny interface{}) bool {
-				client := clientAny.(*websocket.Conn)
-				client.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("%s:%s", clientID, msg)))
-
-				return true
-			})
-
-			log.Printf("[chat] %s->%s: %s\n", clientID, lobbyID, msg)
+		if messageType == websocket.TextMessage && !session.handle(msg) {
+			return
 		}
 	}
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Masks blocked words in chat messages
+type WordFilter struct {
+	pattern *regexp.Regexp // nil if no words are blocked
+}
+
+func NewWordFilter(words []string) *WordFilter {
+	quoted := []string{}
+	for _, w := range words {
+		w = strings.TrimSpace(w)
+		if w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+
+	if len(quoted) == 0 {
+		return &WordFilter{}
+	}
+
+	// Only match whole words, ignoring case
+	return &WordFilter{
+		pattern: regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`),
+	}
+}
+
+// Loads a filter from a file with one blocked word per line. Lines starting with # are ignored
+func LoadWordFilter(path string) (*WordFilter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewWordFilter(words), nil
+}
+
+// Replaces every blocked word in msg with asterisks of the same length
+func (f *WordFilter) Mask(msg string) string {
+	if f == nil || f.pattern == nil {
+		return msg
+	}
+
+	return f.pattern.ReplaceAllStringFunc(msg, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+}
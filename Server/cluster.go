@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// Headers set on requests routed from one node to another. The client's IP is only trusted
+// if the request also carries the cluster secret
+const (
+	headerClusterSecret   = "X-Cluster-Secret"
+	headerClusterClientIP = "X-Cluster-Client-IP"
+)
+
+// Decides which node each lobby lives on. Every node must agree
+type LobbyOwnership interface {
+	// ID of the node which owns the lobby
+	Owner(lobbyID string) string
+	// Whether this node owns the lobby
+	Owns(lobbyID string) bool
+}
+
+// A static set of nodes which split lobbies between them with rendezvous hashing, so adding
+// or removing a node only moves the lobbies it gains or loses
+type Cluster struct {
+	self    string
+	nodes   []string
+	proxies map[string]*httputil.ReverseProxy
+}
+
+// Shared by every node to authenticate routed requests. Set from the config
+var clusterSecret string
+
+func NewCluster(config *Config) (*Cluster, error) {
+	c := &Cluster{
+		self:    config.NodeID,
+		proxies: make(map[string]*httputil.ReverseProxy),
+	}
+
+	// A single node owns everything
+	if len(config.ClusterNodes) == 0 {
+		c.nodes = []string{c.self}
+		return c, nil
+	}
+
+	for _, node := range config.ClusterNodes {
+		id, rawURL, ok := strings.Cut(node, "=")
+		if !ok {
+			return nil, fmt.Errorf("cluster node %q must look like id=http://host:port", node)
+		}
+
+		u, err := url.Parse(rawURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("cluster node %q has an invalid URL", node)
+		}
+
+		c.nodes = append(c.nodes, id)
+		if id != c.self {
+			c.proxies[id] = httputil.NewSingleHostReverseProxy(u)
+		}
+	}
+
+	if len(c.proxies) == len(c.nodes) {
+		return nil, errors.New("cluster_nodes must include node_id")
+	}
+
+	return c, nil
+}
+
+func nodeScore(node string, lobbyID string) uint64 {
+	sum := sha256.Sum256([]byte(node + "\x00" + lobbyID))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// Owner implements LobbyOwnership
+func (c *Cluster) Owner(lobbyID string) string {
+	owner := c.nodes[0]
+	best := nodeScore(owner, lobbyID)
+	for _, node := range c.nodes[1:] {
+		if score := nodeScore(node, lobbyID); score > best {
+			owner, best = node, score
+		}
+	}
+
+	return owner
+}
+
+// Owns implements LobbyOwnership
+func (c *Cluster) Owns(lobbyID string) bool {
+	return c.Owner(lobbyID) == c.self
+}
+
+// Whether the request was routed here by another node
+func routed(r *http.Request) bool {
+	secret := r.Header.Get(headerClusterSecret)
+	return clusterSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(clusterSecret)) == 1
+}
+
+// Proxies sockets for lobbies owned by another node to that node. Clients pick the lobby
+// with the lobby query parameter; sockets without one are handled here
+func (c *Cluster) route(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lobbyID := r.URL.Query().Get("lobby")
+		// Requests which were already routed are never routed again, even if the nodes disagree
+		if lobbyID == "" || c.Owns(lobbyID) || routed(r) {
+			next(w, r)
+			return
+		}
+
+		owner := c.Owner(lobbyID)
+		r.Header.Set(headerClusterClientIP, remoteIP(r))
+		r.Header.Set(headerClusterSecret, clusterSecret)
+		slog.Debug("routing socket", "lobby", lobbyID, "node", owner, "path", r.URL.Path)
+		c.proxies[owner].ServeHTTP(w, r)
+	}
+}
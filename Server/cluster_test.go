@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testClusterSecret = "0123456789abcdef0123456789abcdef"
+
+var testClusterNodes = []string{"a=http://10.0.0.1:8080", "b=http://10.0.0.2:8080", "c=http://10.0.0.3:8080"}
+
+func newTestCluster(t *testing.T, self string, nodes ...string) *Cluster {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.NodeID = self
+	config.ClusterNodes = nodes
+
+	c, err := NewCluster(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// Returns the ID of a lobby the cluster gives to the node
+func lobbyOwnedBy(t *testing.T, c *Cluster, node string) string {
+	t.Helper()
+
+	for i := 0; i < 1000; i++ {
+		if id := fmt.Sprintf("lobby-%d", i); c.Owner(id) == node {
+			return id
+		}
+	}
+
+	t.Fatalf("no lobby is owned by %s", node)
+	return ""
+}
+
+func setClusterSecret(t *testing.T, secret string) {
+	prev := clusterSecret
+	clusterSecret = secret
+	t.Cleanup(func() { clusterSecret = prev })
+}
+
+func TestClusterOwnership(t *testing.T) {
+	clusters := map[string]*Cluster{}
+	for _, node := range []string{"a", "b", "c"} {
+		clusters[node] = newTestCluster(t, node, testClusterNodes...)
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 300; i++ {
+		id := fmt.Sprintf("lobby-%d", i)
+		owner := clusters["a"].Owner(id)
+		counts[owner]++
+
+		for node, c := range clusters {
+			if got := c.Owner(id); got != owner {
+				t.Errorf("%s thinks %s is owned by %s, a thinks %s", node, id, got, owner)
+			}
+
+			if c.Owns(id) != (node == owner) {
+				t.Errorf("%s owns %s is %v, but the owner is %s", node, id, c.Owns(id), owner)
+			}
+		}
+	}
+
+	for node := range clusters {
+		if counts[node] == 0 {
+			t.Errorf("%s owns none of the lobbies", node)
+		}
+	}
+
+	// Removing c only moves the lobbies c owned
+	two := newTestCluster(t, "a", testClusterNodes[:2]...)
+	for i := 0; i < 300; i++ {
+		id := fmt.Sprintf("lobby-%d", i)
+		if owner := clusters["a"].Owner(id); owner != "c" && two.Owner(id) != owner {
+			t.Errorf("%s moved from %s to %s", id, owner, two.Owner(id))
+		}
+	}
+}
+
+func TestSingleNodeOwnsEverything(t *testing.T) {
+	c := newTestCluster(t, "solo")
+	for _, id := range []string{"a", "lobby", "another lobby"} {
+		if !c.Owns(id) {
+			t.Errorf("single node doesn't own %q", id)
+		}
+	}
+}
+
+func TestNewClusterInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []string
+	}{
+		{"missing URL", []string{"a"}},
+		{"invalid URL", []string{"a=not a url"}},
+		{"without this node", []string{"b=http://10.0.0.2:8080"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.NodeID = "a"
+			config.ClusterNodes = tt.nodes
+			if _, err := NewCluster(config); err == nil {
+				t.Errorf("NewCluster(%v) succeeded", tt.nodes)
+			}
+		})
+	}
+}
+
+func TestRouted(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string // Of this node
+		header string
+		want   bool
+	}{
+		{"no header", testClusterSecret, "", false},
+		{"wrong secret", testClusterSecret, "wrong", false},
+		{"right secret", testClusterSecret, testClusterSecret, true},
+		{"not clustered", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setClusterSecret(t, tt.secret)
+
+			r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			if tt.header != "" {
+				r.Header.Set(headerClusterSecret, tt.header)
+			}
+
+			if got := routed(r); got != tt.want {
+				t.Errorf("routed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClusterRoute(t *testing.T) {
+	setClusterSecret(t, testClusterSecret)
+
+	// Node b answers every request routed to it with the headers it got
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Node", "b")
+		w.Header().Set("X-Got-Secret", r.Header.Get(headerClusterSecret))
+		w.Header().Set("X-Got-Client-IP", r.Header.Get(headerClusterClientIP))
+	}))
+	defer b.Close()
+
+	a := newTestCluster(t, "a", "a=http://127.0.0.1:1", "b="+b.URL)
+	handler := a.route(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Node", "a")
+	})
+
+	tests := []struct {
+		name   string
+		lobby  string
+		secret string // Sent along by the client
+		want   string // Node which handles the request
+	}{
+		{"no lobby", "", "", "a"},
+		{"owned here", lobbyOwnedBy(t, a, "a"), "", "a"},
+		{"owned by b", lobbyOwnedBy(t, a, "b"), "", "b"},
+		{"already routed", lobbyOwnedBy(t, a, "b"), testClusterSecret, "a"},
+		{"forged secret", lobbyOwnedBy(t, a, "b"), "forged", "b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ws?lobby="+tt.lobby, nil)
+			r.RemoteAddr = "192.0.2.7:4000"
+			if tt.secret != "" {
+				r.Header.Set(headerClusterSecret, tt.secret)
+			}
+
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if got := w.Header().Get("X-Node"); got != tt.want {
+				t.Fatalf("handled by %q, want %q", got, tt.want)
+			}
+
+			if tt.want != "b" {
+				return
+			}
+
+			if got := w.Header().Get("X-Got-Secret"); got != testClusterSecret {
+				t.Errorf("b got secret %q", got)
+			}
+
+			if got := w.Header().Get("X-Got-Client-IP"); got != "192.0.2.7" {
+				t.Errorf("b got client IP %q, want 192.0.2.7", got)
+			}
+		})
+	}
+}
+
+// Two nodes sharing a bus. Node b owns the lobby, and node a holds chat and voice connections
+// of the second client, which get everything b sends them
+func TestClustersShareBus(t *testing.T) {
+	bus := NewLocalPubSub()
+	clusters := map[string]*Cluster{}
+	managers := map[string]*LobbyManager{}
+	for _, node := range []string{"a", "b"} {
+		config := DefaultConfig()
+		config.NodeID = node
+		config.ClusterNodes = testClusterNodes[:2]
+		config.MaxLobbiesPerIP = 0
+
+		cluster, err := NewCluster(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lm, err := NewLobbyManager(config, cluster, bus)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clusters[node], managers[node] = cluster, lm
+	}
+
+	lobbyID := lobbyOwnedBy(t, clusters["a"], "b")
+
+	// Only the owner hosts the lobby
+	c := NewGameServer(managers["a"]).newClient(nopChannel{}, "10.0.0.3")
+	c.Sync()
+	if err := managers["a"].ExecuteMoves(c, []string{MoveJoin}, map[string]interface{}{"lobby": lobbyID, "name": "Player"}); err != errLobbyElsewhere {
+		t.Fatalf("joining through a returned %v, want errLobbyElsewhere", err)
+	}
+
+	lm := managers["b"]
+	leader, clientID := joinTwo(t, lm, NewGameServer(lm), lobbyID)
+	_, leaderID, _ := lm.Member(leader)
+
+	remoteChat, remoteVoice := newChatLobby(lobbyID, bus), newVoiceLobby(lobbyID, bus)
+	remoteChat.subscribe()
+	remoteVoice.subscribe()
+	defer remoteChat.close()
+	defer remoteVoice.close()
+
+	chatConn, voiceConn := &recordChannel{}, &recordChannel{}
+	remoteChat.join(clientID, chatConn)
+	remoteVoice.join(&VoicePeer{VoicePeerState: VoicePeerState{ID: clientID}, conn: voiceConn})
+
+	cs := NewChatServer(lm, NewWordFilter(nil)).open(&recordChannel{}, lobbyID, leaderID)
+	vs := NewVoiceServer(lm).open(&recordChannel{}, lobbyID, leaderID)
+	if cs == nil || vs == nil {
+		t.Fatal("couldn't open chat and voice on b")
+	}
+
+	cs.handle([]byte("hello from b"))
+	if !strings.Contains(strings.Join(chatConn.messages(), "\n"), "hello from b") {
+		t.Errorf("chat message didn't reach a, got %v", chatConn.messages())
+	}
+
+	vs.handle([]byte(`{"type":"offer","to":"` + clientID + `","data":{"type":"offer","sdp":"v=0"}}`))
+	if !strings.Contains(strings.Join(voiceConn.messages(), "\n"), `"sdp":"v=0"`) {
+		t.Errorf("voice offer didn't reach a, got %v", voiceConn.messages())
+	}
+
+	// Kicking the client on b closes their connections on a
+	if err := lm.ExecuteMoves(leader, []string{MoveKick}, map[string]interface{}{"id": clientID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !chatConn.isClosed() || !voiceConn.isClosed() {
+		t.Error("kick didn't close the connections on a")
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Addr            string        `yaml:"addr"`
+	StaticDir       string        `yaml:"static_dir"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	JWTSecret       string        `yaml:"jwt_secret"`      // Random on every start if empty
+	AllowedOrigins  []string      `yaml:"allowed_origins"` // Any origin is allowed if empty
+	BotInterval     time.Duration `yaml:"bot_interval"`    // How long bots wait between moves
+	MaxLobbies      int           `yaml:"max_lobbies"`     // 0 for no limit
+	MaxPlayers      int           `yaml:"max_players"`     // Per lobby including bots, 0 for no limit
+	ChatFilterFile  string        `yaml:"chat_filter_file"`
+	ShutdownGrace   time.Duration `yaml:"shutdown_grace"`     // How long players are warned before the server stops
+	SnapshotDir     string        `yaml:"snapshot_dir"`       // Where lobbies are saved on shutdown
+	LogLevel        string        `yaml:"log_level"`          // debug, info, warn or error
+	LogFormat       string        `yaml:"log_format"`         // text or json
+	LogPayloads     bool          `yaml:"log_payloads"`       // Log chat messages and voice signaling instead of redacting them
+	AdminToken      string        `yaml:"admin_token"`        // The admin API and dashboard are disabled if empty
+	MaxConnsPerIP   int           `yaml:"max_conns_per_ip"`   // Open sockets of any kind, 0 for no limit
+	MaxLobbiesPerIP int           `yaml:"max_lobbies_per_ip"` // Lobbies created, 0 for no limit
+	MaxBots         int           `yaml:"max_bots"`           // Per lobby, on top of the selected game's limit. 0 for no limit
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`       // Game sockets without messages for this long are closed, 0 for never
+	TLSCertFile     string        `yaml:"tls_cert_file"`      // Serves HTTPS on addr if set, reloaded when it changes
+	TLSKeyFile      string        `yaml:"tls_key_file"`
+	RedirectAddr    string        `yaml:"redirect_addr"` // Where to redirect HTTP to HTTPS from, e.g. :80. Only with TLS
+	NodeID          string        `yaml:"node_id"`
+	ClusterNodes    []string      `yaml:"cluster_nodes"`  // id=url of every node including this one, single node if empty
+	ClusterSecret   string        `yaml:"cluster_secret"` // Authenticates sockets routed between nodes
+
+	BlackjackStandSoft17 bool `yaml:"blackjack_stand_soft_17"` // Whether the Blackjack dealer stands on a soft 17 instead of hitting
+
+	CrazyEightsSpecials []string `yaml:"crazy_eights_specials"` // rank=effect of every special rank in Crazy Eights
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:            ":8080",
+		StaticDir:       "dist/",
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    10 * time.Second,
+		BotInterval:     1500 * time.Millisecond,
+		ShutdownGrace:   10 * time.Second,
+		SnapshotDir:     "snapshots/",
+		MaxConnsPerIP:   20,
+		MaxLobbiesPerIP: 5,
+		IdleTimeout:     30 * time.Minute,
+		NodeID:          "local",
+		LogLevel:        "info",
+		LogFormat:       "text",
+
+		BlackjackStandSoft17: true,
+
+		CrazyEightsSpecials: []string{"8=wild", "7=draw_two", "A=skip"},
+	}
+}
+
+// A single option which can be given in the config file (by name), as an environment
+// variable (CG_ followed by the upper case name) or as a flag (name with dashes)
+type configOption struct {
+	name  string
+	usage string
+	get   func(c *Config) string
+	set   func(c *Config, v string) error
+}
+
+func (o *configOption) env() string {
+	return "CG_" + strings.ToUpper(o.name)
+}
+
+func (o *configOption) flag() string {
+	return strings.ReplaceAll(o.name, "_", "-")
+}
+
+func stringOption(name string, usage string, field func(c *Config) *string) *configOption {
+	return &configOption{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return *field(c) },
+		set: func(c *Config, v string) error {
+			*field(c) = v
+			return nil
+		},
+	}
+}
+
+func durationOption(name string, usage string, field func(c *Config) *time.Duration) *configOption {
+	return &configOption{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return field(c).String() },
+		set: func(c *Config, v string) (err error) {
+			*field(c), err = time.ParseDuration(v)
+			return
+		},
+	}
+}
+
+func intOption(name string, usage string, field func(c *Config) *int) *configOption {
+	return &configOption{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, v string) (err error) {
+			*field(c), err = strconv.Atoi(v)
+			return
+		},
+	}
+}
+
+func boolOption(name string, usage string, field func(c *Config) *bool) *configOption {
+	return &configOption{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return strconv.FormatBool(*field(c)) },
+		set: func(c *Config, v string) (err error) {
+			*field(c), err = strconv.ParseBool(v)
+			return
+		},
+	}
+}
+
+func listOption(name string, usage string, field func(c *Config) *[]string) *configOption {
+	return &configOption{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return strings.Join(*field(c), ",") },
+		set: func(c *Config, v string) error {
+			*field(c) = []string{}
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*field(c) = append(*field(c), item)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+var configOptions = []*configOption{
+	stringOption("addr", "address to listen on", func(c *Config) *string { return &c.Addr }),
+	stringOption("static_dir", "directory of the website to serve", func(c *Config) *string { return &c.StaticDir }),
+	durationOption("read_timeout", "HTTP read timeout", func(c *Config) *time.Duration { return &c.ReadTimeout }),
+	durationOption("write_timeout", "HTTP write timeout", func(c *Config) *time.Duration { return &c.WriteTimeout }),
+	stringOption("jwt_secret", "secret for signing chat/voice keys, random if empty", func(c *Config) *string { return &c.JWTSecret }),
+	listOption("allowed_origins", "comma separated origins allowed to open sockets, any if empty", func(c *Config) *[]string { return &c.AllowedOrigins }),
+	durationOption("bot_interval", "time bots wait between moves", func(c *Config) *time.Duration { return &c.BotInterval }),
+	intOption("max_lobbies", "maximum number of lobbies, 0 for no limit", func(c *Config) *int { return &c.MaxLobbies }),
+	intOption("max_players", "maximum number of players per lobby including bots, 0 for no limit", func(c *Config) *int { return &c.MaxPlayers }),
+	stringOption("chat_filter_file", "file with one word per line to mask in chat", func(c *Config) *string { return &c.ChatFilterFile }),
+	durationOption("shutdown_grace", "how long players are warned before the server shuts down", func(c *Config) *time.Duration { return &c.ShutdownGrace }),
+	stringOption("snapshot_dir", "directory lobbies are saved to on shutdown", func(c *Config) *string { return &c.SnapshotDir }),
+	intOption("max_conns_per_ip", "maximum number of open sockets per IP, 0 for no limit", func(c *Config) *int { return &c.MaxConnsPerIP }),
+	intOption("max_lobbies_per_ip", "maximum number of lobbies created per IP, 0 for no limit", func(c *Config) *int { return &c.MaxLobbiesPerIP }),
+	intOption("max_bots", "maximum number of bots per lobby, 0 for no limit besides the game's", func(c *Config) *int { return &c.MaxBots }),
+	durationOption("idle_timeout", "how long game sockets may go without a message before they are closed, 0 for never", func(c *Config) *time.Duration { return &c.IdleTimeout }),
+	stringOption("tls_cert_file", "certificate file to serve HTTPS with, plain HTTP if empty", func(c *Config) *string { return &c.TLSCertFile }),
+	stringOption("tls_key_file", "private key file for tls_cert_file", func(c *Config) *string { return &c.TLSKeyFile }),
+	stringOption("redirect_addr", "address to redirect HTTP to HTTPS from when TLS is on, none if empty", func(c *Config) *string { return &c.RedirectAddr }),
+	stringOption("node_id", "ID of this node in cluster_nodes", func(c *Config) *string { return &c.NodeID }),
+	listOption("cluster_nodes", "comma separated id=url of every node including this one, single node if empty", func(c *Config) *[]string { return &c.ClusterNodes }),
+	stringOption("cluster_secret", "secret shared by every node to authenticate routed sockets", func(c *Config) *string { return &c.ClusterSecret }),
+	stringOption("log_level", "minimum level to log: debug, info, warn or error", func(c *Config) *string { return &c.LogLevel }),
+	stringOption("log_format", "log format: text or json", func(c *Config) *string { return &c.LogFormat }),
+	stringOption("admin_token", "bearer token for the admin API and dashboard, disabled if empty", func(c *Config) *string { return &c.AdminToken }),
+	boolOption("blackjack_stand_soft_17", "whether the Blackjack dealer stands on a soft 17 instead of hitting", func(c *Config) *bool { return &c.BlackjackStandSoft17 }),
+	listOption("crazy_eights_specials", "comma separated rank=effect of the special ranks in Crazy Eights, each effect being wild, draw_two, skip or reverse", func(c *Config) *[]string { return &c.CrazyEightsSpecials }),
+	boolOption("log_payloads", "log chat messages and voice signaling payloads instead of redacting them", func(c *Config) *bool { return &c.LogPayloads }),
+}
+
+// Builds the config from defaults, then the config file, then environment variables, then flags
+func LoadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	defaults := DefaultConfig()
+	configFile := fs.String("config", os.Getenv("CG_CONFIG"), "path to a YAML config file")
+	for _, o := range configOptions {
+		fs.String(o.flag(), o.get(defaults), o.usage+" (env "+o.env()+")")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	config := DefaultConfig()
+	if *configFile != "" {
+		f, err := os.Open(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		decoder := yaml.NewDecoder(f)
+		decoder.KnownFields(true)
+		// An empty file is fine
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", *configFile, err)
+		}
+	}
+
+	for _, o := range configOptions {
+		if v, ok := os.LookupEnv(o.env()); ok {
+			if err := o.set(config, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", o.env(), err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, o := range configOptions {
+			if err == nil && f.Name == o.flag() {
+				if e := o.set(config, f.Value.String()); e != nil {
+					err = fmt.Errorf("-%s: %w", f.Name, e)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return config, config.Validate()
+}
+
+func (c *Config) Validate() error {
+	if c.Addr == "" {
+		return errors.New("addr must not be empty")
+	}
+
+	if info, err := os.Stat(c.StaticDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("static_dir %q is not a directory", c.StaticDir)
+	}
+
+	if c.ReadTimeout <= 0 || c.WriteTimeout <= 0 {
+		return errors.New("timeouts must be positive")
+	}
+
+	if c.JWTSecret != "" && len(c.JWTSecret) < 32 {
+		return errors.New("jwt_secret must be at least 32 characters")
+	}
+
+	for _, origin := range c.AllowedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("allowed origin %q must look like https://example.com", origin)
+		}
+	}
+
+	if c.BotInterval < 100*time.Millisecond {
+		return errors.New("bot_interval must be at least 100ms")
+	}
+
+	if c.ShutdownGrace < 0 {
+		return errors.New("shutdown_grace must not be negative")
+	}
+
+	if c.SnapshotDir == "" {
+		return errors.New("snapshot_dir must not be empty")
+	}
+
+	if c.AdminToken != "" && len(c.AdminToken) < 16 {
+		return errors.New("admin_token must be at least 16 characters")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return fmt.Errorf("log_level %q must be debug, info, warn or error", c.LogLevel)
+	}
+
+	if !strings.EqualFold(c.LogFormat, "text") && !strings.EqualFold(c.LogFormat, "json") {
+		return fmt.Errorf("log_format %q must be text or json", c.LogFormat)
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("tls_cert_file and tls_key_file must be set together")
+	}
+
+	if c.RedirectAddr != "" && c.TLSCertFile == "" {
+		return errors.New("redirect_addr needs tls_cert_file and tls_key_file")
+	}
+
+	if c.NodeID == "" {
+		return errors.New("node_id must not be empty")
+	}
+
+	if len(c.ClusterNodes) > 0 && len(c.ClusterSecret) < 32 {
+		return errors.New("cluster_secret must be at least 32 characters when cluster_nodes is set")
+	}
+
+	if c.IdleTimeout < 0 {
+		return errors.New("idle_timeout must not be negative")
+	}
+
+	if _, err := parseCrazyEightsSpecials(c.CrazyEightsSpecials); err != nil {
+		return err
+	}
+
+	if c.MaxLobbies < 0 || c.MaxPlayers < 0 || c.MaxConnsPerIP < 0 || c.MaxLobbiesPerIP < 0 || c.MaxBots < 0 {
+		return errors.New("limits must not be negative")
+	}
+
+	return nil
+}
+
+// Logs every option, hiding secrets
+func (c *Config) Print() {
+	for _, o := range configOptions {
+		v := o.get(c)
+		switch {
+		case o.name == "jwt_secret" && v == "":
+			v = "<random>"
+		case o.name == "admin_token" && v == "":
+			v = "<disabled>"
+		case v != "" && (o.name == "jwt_secret" || o.name == "admin_token" || o.name == "cluster_secret"):
+			v = "<hidden>"
+		}
+
+		slog.Info("config", "option", o.name, "value", v)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Cards of a French-suited deck, stored as ints so they work with Pile and Hands.
+// 0-51: 2C, 3C, ..., AC, 2D, ..., AD, 2H, ..., AH, 2S, ..., AS
+// 52: black joker, 53: red joker
+// card = suit*13 + rank-2
+// In states and moves cards are written as short codes: rank then suit, e.g. "QH", "TC"
+// or "2S", and "BJ"/"RJ" for the jokers
+
+type Suit int
+
+// In the order used for sorting
+const (
+	Clubs Suit = iota
+	Diamonds
+	Hearts
+	Spades
+	// The suit of jokers
+	NoSuit Suit = -1
+)
+
+var Suits = []Suit{Clubs, Diamonds, Hearts, Spades}
+
+const suitCodes = "CDHS"
+
+func (s Suit) String() string {
+	if s < Clubs || s > Spades {
+		return ""
+	}
+
+	return string(suitCodes[s])
+}
+
+// Suits are sent as their codes, and NoSuit as an empty string
+func (s Suit) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s Suit) Red() bool {
+	return s == Diamonds || s == Hearts
+}
+
+type Rank int
+
+// Aces are high. Games where they are low have to say so when comparing
+const (
+	Two Rank = iota + 2
+	Three
+	Four
+	Five
+	Six
+	Seven
+	Eight
+	Nine
+	Ten
+	Jack
+	Queen
+	King
+	Ace
+	// The rank of jokers, above every other card
+	Joker
+)
+
+const rankCodes = "23456789TJQKA"
+
+func (r Rank) String() string {
+	if r < Two || r > Ace {
+		return ""
+	}
+
+	return string(rankCodes[r-Two])
+}
+
+type Card int
+
+const (
+	BlackJoker Card = 52
+	RedJoker   Card = 53
+)
+
+func NewCard(rank Rank, suit Suit) Card {
+	return Card(int(suit)*13 + int(rank-Two))
+}
+
+func (c Card) Suit() Suit {
+	if c.IsJoker() {
+		return NoSuit
+	}
+
+	return Suit(c / 13)
+}
+
+func (c Card) Rank() Rank {
+	if c.IsJoker() {
+		return Joker
+	}
+
+	return Rank(c%13) + Two
+}
+
+func (c Card) IsJoker() bool {
+	return c == BlackJoker || c == RedJoker
+}
+
+func (c Card) Red() bool {
+	return c == RedJoker || c.Suit().Red()
+}
+
+func (c Card) Valid() bool {
+	return c >= 0 && c <= RedJoker
+}
+
+// The card's short code, e.g. "QH"
+func (c Card) String() string {
+	switch c {
+	case BlackJoker:
+		return "BJ"
+	case RedJoker:
+		return "RJ"
+	}
+
+	if !c.Valid() {
+		return ""
+	}
+
+	return c.Rank().String() + c.Suit().String()
+}
+
+// Parses a short code. "10" is accepted for tens and case is ignored
+func ParseCardCode(code string) (Card, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	switch code {
+	case "BJ":
+		return BlackJoker, nil
+	case "RJ":
+		return RedJoker, nil
+	}
+
+	code = strings.Replace(code, "10", "T", 1)
+	if len(code) != 2 {
+		return 0, fmt.Errorf("invalid card %q", code)
+	}
+
+	rank := strings.IndexByte(rankCodes, code[0])
+	suit := strings.IndexByte(suitCodes, code[1])
+	if rank < 0 || suit < 0 {
+		return 0, fmt.Errorf("invalid card %q", code)
+	}
+
+	return NewCard(Rank(rank)+Two, Suit(suit)), nil
+}
+
+// Parses the code of a rank, like "Q". "10" is accepted for tens and case is ignored
+func ParseRankCode(code string) (Rank, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	code = strings.Replace(code, "10", "T", 1)
+	rank := strings.Index(rankCodes, code)
+	if len(code) != 1 || rank < 0 {
+		return 0, fmt.Errorf("invalid rank %q", code)
+	}
+
+	return Rank(rank) + Two, nil
+}
+
+// Cards are sent as their short codes
+func (c Card) MarshalText() ([]byte, error) {
+	if !c.Valid() {
+		return nil, fmt.Errorf("invalid card %d", int(c))
+	}
+
+	return []byte(c.String()), nil
+}
+
+func (c *Card) UnmarshalText(text []byte) error {
+	card, err := ParseCardCode(string(text))
+	if err != nil {
+		return err
+	}
+
+	*c = card
+	return nil
+}
+
+// Creates an unshuffled pile of n standard decks, each with two jokers if jokers is true
+func NewDeck(n int, jokers bool) *Pile {
+	pile := Pile{}
+	for i := 0; i < n; i++ {
+		for c := Card(0); c < BlackJoker; c++ {
+			pile = append(pile, int(c))
+		}
+
+		if jokers {
+			pile = append(pile, int(BlackJoker), int(RedJoker))
+		}
+	}
+
+	return &pile
+}
+
+// Removes every card for which keep returns false, e.g. to make a short deck
+func (p *Pile) Filter(keep func(c Card) bool) {
+	kept := (*p)[:0]
+	for _, c := range *p {
+		if keep(Card(c)) {
+			kept = append(kept, c)
+		}
+	}
+
+	*p = kept
+}
+
+type Cards []Card
+
+// The cards of a pile made with NewDeck, e.g. to send a hand as short codes
+func (p *Pile) Cards() Cards {
+	cards := make(Cards, len(*p))
+	for i, c := range *p {
+		cards[i] = Card(c)
+	}
+
+	return cards
+}
+
+func (cs Cards) Pile() *Pile {
+	pile := make(Pile, len(cs))
+	for i, c := range cs {
+		pile[i] = int(c)
+	}
+
+	return &pile
+}
+
+// Always encoded as an array, even when empty
+func (cs Cards) MarshalJSON() ([]byte, error) {
+	if cs == nil {
+		return []byte("[]"), nil
+	}
+
+	return json.Marshal([]Card(cs))
+}
+
+// Reads a list of short codes sent in a packet's data
+func CardsFromData(data interface{}, key string) (Cards, error) {
+	raw, _ := Get[[]interface{}](data, key)
+	cards := Cards{}
+	for _, r := range raw {
+		code, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a list of cards", key)
+		}
+
+		c, err := ParseCardCode(code)
+		if err != nil {
+			return nil, err
+		}
+
+		cards = append(cards, c)
+	}
+
+	return cards, nil
+}
+
+// Compares two cards by rank, then by suit. Returns a negative number if a is lower,
+// a positive number if a is higher and 0 if they are the same card
+func CompareCards(a Card, b Card) int {
+	if a.Rank() != b.Rank() {
+		return int(a.Rank()) - int(b.Rank())
+	}
+
+	if a.Suit() != b.Suit() {
+		return int(a.Suit()) - int(b.Suit())
+	}
+
+	// The jokers share a rank and suit
+	return int(a) - int(b)
+}
+
+// Sorts by suit, then by rank, the way most players hold their hand
+func (cs Cards) Sort() {
+	sort.SliceStable(cs, func(i, j int) bool {
+		if cs[i].Suit() != cs[j].Suit() {
+			return cs[i].Suit() < cs[j].Suit()
+		}
+
+		return cs[i].Rank() < cs[j].Rank()
+	})
+}
+
+// Sorts by rank, then by suit, highest first
+func (cs Cards) SortByRank() {
+	sort.SliceStable(cs, func(i, j int) bool {
+		return CompareCards(cs[i], cs[j]) > 0
+	})
+}
+
+// The cards of the given suit
+func (cs Cards) OfSuit(suit Suit) Cards {
+	of := Cards{}
+	for _, c := range cs {
+		if c.Suit() == suit {
+			of = append(of, c)
+		}
+	}
+
+	return of
+}
+
+func (cs Cards) Contains(c Card) bool {
+	for _, o := range cs {
+		if o == c {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Removes one copy of the card from the pile. Returns false if it isn't in the pile
+func (p *Pile) Remove(c Card) bool {
+	for i, o := range *p {
+		if Card(o) == c {
+			*p = append((*p)[:i], (*p)[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// The cards in each hand as short codes, sorted, for states that reveal them
+func (h *Hands) Cards() map[string]Cards {
+	hands := make(map[string]Cards)
+	for id, p := range *h {
+		cards := p.Cards()
+		cards.Sort()
+		hands[id] = cards
+	}
+
+	return hands
+}
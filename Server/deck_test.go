@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCardCodeRoundTrip(t *testing.T) {
+	for c := Card(0); c <= RedJoker; c++ {
+		code := c.String()
+		got, err := ParseCardCode(code)
+		if err != nil {
+			t.Errorf("ParseCardCode(%q) of card %d failed: %v", code, int(c), err)
+			continue
+		}
+
+		if got != c {
+			t.Errorf("ParseCardCode(%q) = %d, want %d", code, int(got), int(c))
+		}
+	}
+}
+
+func TestParseCardCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want Card
+	}{
+		{"2C", NewCard(Two, Clubs)},
+		{"AC", NewCard(Ace, Clubs)},
+		{"2D", NewCard(Two, Diamonds)},
+		{"QH", NewCard(Queen, Hearts)},
+		{"AS", NewCard(Ace, Spades)},
+		{"TC", NewCard(Ten, Clubs)},
+		{"10C", NewCard(Ten, Clubs)},
+		{"qh", NewCard(Queen, Hearts)},
+		{" KD ", NewCard(King, Diamonds)},
+		{"BJ", BlackJoker},
+		{"rj", RedJoker},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			got, err := ParseCardCode(tt.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("ParseCardCode(%q) = %v, want %v", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCardCodeInvalid(t *testing.T) {
+	for _, code := range []string{"", "A", "1C", "11C", "AX", "XS", "CA", "ACE", "JJ", "52"} {
+		if c, err := ParseCardCode(code); err == nil {
+			t.Errorf("ParseCardCode(%q) = %v, want an error", code, c)
+		}
+	}
+}
+
+func TestCardProperties(t *testing.T) {
+	tests := []struct {
+		card  Card
+		rank  Rank
+		suit  Suit
+		red   bool
+		joker bool
+	}{
+		{NewCard(Two, Clubs), Two, Clubs, false, false},
+		{NewCard(Ace, Diamonds), Ace, Diamonds, true, false},
+		{NewCard(Ten, Hearts), Ten, Hearts, true, false},
+		{NewCard(King, Spades), King, Spades, false, false},
+		{BlackJoker, Joker, NoSuit, false, true},
+		{RedJoker, Joker, NoSuit, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.card.String(), func(t *testing.T) {
+			if tt.card.Rank() != tt.rank || tt.card.Suit() != tt.suit {
+				t.Errorf("rank %v and suit %v, want %v and %v", tt.card.Rank(), tt.card.Suit(), tt.rank, tt.suit)
+			}
+
+			if tt.card.Red() != tt.red || tt.card.IsJoker() != tt.joker {
+				t.Errorf("red %v and joker %v, want %v and %v", tt.card.Red(), tt.card.IsJoker(), tt.red, tt.joker)
+			}
+		})
+	}
+
+	for _, c := range []Card{-1, RedJoker + 1} {
+		if c.Valid() || c.String() != "" {
+			t.Errorf("card %d is valid or has code %q", int(c), c.String())
+		}
+	}
+}
+
+func TestParseRankCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want Rank
+	}{
+		{"2", Two},
+		{"9", Nine},
+		{"T", Ten},
+		{"10", Ten},
+		{"j", Jack},
+		{"Q", Queen},
+		{" K", King},
+		{"A", Ace},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			got, err := ParseRankCode(tt.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("ParseRankCode(%q) = %v, want %v", tt.code, got, tt.want)
+			}
+
+			if got.String() != strings.Replace(strings.ToUpper(strings.TrimSpace(tt.code)), "10", "T", 1) {
+				t.Errorf("%v has code %q", got, got.String())
+			}
+		})
+	}
+
+	for _, code := range []string{"", "1", "11", "B", "JQ", "AS", "23"} {
+		if r, err := ParseRankCode(code); err == nil {
+			t.Errorf("ParseRankCode(%q) = %v, want an error", code, r)
+		}
+	}
+}
+
+func TestCardJSON(t *testing.T) {
+	cards := parseHand(t, "2C TD QH AS BJ RJ")
+	data, err := json.Marshal(cards)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := `["2C","TD","QH","AS","BJ","RJ"]`; string(data) != want {
+		t.Errorf("marshalled to %s, want %s", data, want)
+	}
+
+	var got Cards
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, cards) {
+		t.Errorf("unmarshalled to %v, want %v", got, cards)
+	}
+
+	if data, _ := json.Marshal(Cards(nil)); string(data) != "[]" {
+		t.Errorf("nil cards marshalled to %s", data)
+	}
+
+	if _, err := json.Marshal(Card(-1)); err == nil {
+		t.Error("invalid card marshalled")
+	}
+}
+
+func TestCompareCards(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int // Only the sign matters
+	}{
+		{"3C", "2S", 1},
+		{"KH", "AC", -1},
+		{"QS", "QH", 1},
+		{"QC", "QD", -1},
+		{"7D", "7D", 0},
+		{"BJ", "AS", 1},
+		{"RJ", "BJ", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			a, b := parseHand(t, tt.a)[0], parseHand(t, tt.b)[0]
+			got := CompareCards(a, b)
+			if (got > 0) != (tt.want > 0) || (got < 0) != (tt.want < 0) {
+				t.Errorf("CompareCards(%s, %s) = %d, want the sign of %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCardsSort(t *testing.T) {
+	tests := []struct {
+		name   string
+		cards  string
+		sorted string
+		byRank string
+	}{
+		{"one suit", "KH 2H TH", "2H TH KH", "KH TH 2H"},
+		{"suits", "AS 2C KD 3H 2S", "2C KD 3H 2S AS", "AS KD 3H 2S 2C"},
+		{"pairs", "9D 9S 9C 9H", "9C 9D 9H 9S", "9S 9H 9D 9C"},
+		{"jokers first", "RJ 5C BJ AS", "RJ BJ 5C AS", "RJ BJ AS 5C"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cards := parseHand(t, tt.cards)
+			cards.Sort()
+			if got := parseHand(t, tt.sorted); !reflect.DeepEqual(cards, got) {
+				t.Errorf("Sort() = %v, want %v", cards, got)
+			}
+
+			cards = parseHand(t, tt.cards)
+			cards.SortByRank()
+			if got := parseHand(t, tt.byRank); !reflect.DeepEqual(cards, got) {
+				t.Errorf("SortByRank() = %v, want %v", cards, got)
+			}
+		})
+	}
+}
+
+func TestPileRemove(t *testing.T) {
+	pile := parseHand(t, "2C 5D 2C").Pile()
+	if !pile.Remove(NewCard(Two, Clubs)) {
+		t.Fatal("2C wasn't removed")
+	}
+
+	if got := pile.Cards(); !reflect.DeepEqual(got, parseHand(t, "5D 2C")) {
+		t.Errorf("left %v, want only one copy removed", got)
+	}
+
+	if pile.Remove(NewCard(Ace, Spades)) {
+		t.Error("removed a card that isn't in the pile")
+	}
+}
+
+func TestNewDeck(t *testing.T) {
+	if got := len(*NewDeck(2, true)); got != 108 {
+		t.Errorf("two decks with jokers have %d cards, want 108", got)
+	}
+
+	deck := NewDeck(1, false)
+	seen := map[Card]bool{}
+	for _, c := range deck.Cards() {
+		if seen[c] || c.IsJoker() {
+			t.Errorf("%v dealt twice or a joker", c)
+		}
+		seen[c] = true
+	}
+
+	if len(seen) != 52 {
+		t.Errorf("deck has %d distinct cards, want 52", len(seen))
+	}
+
+	deck.Filter(func(c Card) bool { return c.Rank() >= Seven })
+	if got := len(*deck); got != 32 {
+		t.Errorf("short deck has %d cards, want 32", got)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"math/rand"
+	"sync"
 
 	"golang.org/x/exp/slices"
 )
@@ -29,6 +30,37 @@ type SmartGame interface {
 	SelectMoves(client *Client, moves []string) []string
 }
 
+type SmartDataGame interface {
+	SmartGame
+	// The data sent along with the moves a bot selected, like the cards of a pass
+	SelectData(client *Client, moves []string) interface{}
+}
+
+type InspectableGame interface {
+	FreezableGame
+	// Returns the whole state of the game, including anything hidden from players
+	Inspect() interface{}
+}
+
+// Rules of games that can be changed in the config. Every lobby is created with them and
+// passes them on to the games it starts
+type GameRules struct {
+	BlackjackStandSoft17 bool                       // Whether the Blackjack dealer stands on a soft 17 instead of hitting it
+	CrazyEightsSpecials  map[Rank]CrazyEightsEffect // What the special ranks of Crazy Eights do
+}
+
+func NewGameRules(config *Config) (*GameRules, error) {
+	specials, err := parseCrazyEightsSpecials(config.CrazyEightsSpecials)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GameRules{
+		BlackjackStandSoft17: config.BlackjackStandSoft17,
+		CrazyEightsSpecials:  specials,
+	}, nil
+}
+
 type GameData struct {
 	Create     func(*Lobby) FreezableGame
 	Name       string
@@ -38,6 +70,32 @@ type GameData struct {
 
 var GAMES = make(map[string]*GameData)
 
+// Names of games in GAMES which players can't select right now
+var disabledGames sync.Map
+
+func gameEnabled(name string) bool {
+	_, disabled := disabledGames.Load(name)
+	return !disabled
+}
+
+func setGameEnabled(name string, enabled bool) {
+	if enabled {
+		disabledGames.Delete(name)
+	} else {
+		disabledGames.Store(name, true)
+	}
+}
+
+func disabledGameNames() []string {
+	names := []string{}
+	disabledGames.Range(func(name, _ interface{}) bool {
+		names = append(names, name.(string))
+		return true
+	})
+
+	return names
+}
+
 func registerGame(g *GameData) {
 	GAMES[g.Name] = g
 }
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Everyone plays their own hands against a dealer run by the server, all at the same time.
+// Cards come from a shoe of several decks that is reshuffled once most of it has been dealt.
+// Blackjack pays 3 to 2 and insurance 2 to 1. The game ends after a set number of rounds or
+// once everyone is out of chips, and whoever has the most chips wins
+
+const (
+	moveBet         = "bet"
+	moveHit         = "hit"
+	moveStand       = "stand"
+	moveDouble      = "double"
+	moveSplit       = "split"
+	moveInsurance   = "insurance"
+	moveNoInsurance = "no_insurance"
+)
+
+const (
+	blackjackDecks       = 6
+	blackjackPenetration = 0.75 // How much of the shoe is dealt before it is reshuffled
+	blackjackStack       = 1000
+	blackjackMinBet      = 10
+	blackjackMaxBet      = 500
+	blackjackMaxHands    = 4 // How many hands a player can split into
+	blackjackRounds      = 15
+	blackjackResultDelay = 5 * time.Second
+)
+
+type BlackjackPhase string
+
+const (
+	BlackjackPhaseBet       BlackjackPhase = "bet"
+	BlackjackPhaseInsurance BlackjackPhase = "insurance"
+	BlackjackPhasePlay      BlackjackPhase = "play"
+	BlackjackPhaseSettle    BlackjackPhase = "settle" // The round is over and the next is about to start
+	BlackjackPhaseDone      BlackjackPhase = "done"   // The game is over
+)
+
+type BlackjackResult string
+
+const (
+	BlackjackWin       BlackjackResult = "win"
+	BlackjackLose      BlackjackResult = "lose"
+	BlackjackPush      BlackjackResult = "push"
+	BlackjackBlackjack BlackjackResult = "blackjack"
+)
+
+type BlackjackHand struct {
+	Cards  Cards           `json:"cards"`
+	Bet    int             `json:"bet"`
+	Done   bool            `json:"done"` // Stood, doubled or busted
+	Split  bool            `json:"split"`
+	Result BlackjackResult `json:"result,omitempty"`
+}
+
+type BlackjackGame struct {
+	*Betting
+	Phase       BlackjackPhase              `json:"phase"`
+	PlayerOrder []string                    `json:"player_order"`
+	Round       int                         `json:"round"`
+	Hands       map[string][]*BlackjackHand `json:"hands"`
+	Insurance   map[string]int              `json:"insurance"` // -1 if declined
+	Net         map[string]int              `json:"net"`       // What each player won or lost this round
+	Dealer      Cards                       `json:"dealer"`    // Only the up card until the dealer plays
+	ShoeLeft    int                         `json:"shoe_left"`
+	StandSoft17 bool                        `json:"stand_soft_17"`
+	Winners     []string                    `json:"winners"`
+
+	lobby     *Lobby
+	shoe      *Pile
+	holeCard  Card
+	before    map[string]int // Stacks at the start of the round
+	reshuffle int            // Reshuffle once the shoe has fewer cards than this
+}
+
+type BlackjackState struct {
+	BlackjackGame
+	Lobby *LobbyState `json:"lobby"`
+}
+
+func NewBlackjack(l *Lobby) FreezableGame {
+	g := &BlackjackGame{
+		StandSoft17: l.rules.BlackjackStandSoft17,
+		Winners:     []string{},
+		lobby:       l,
+		shoe:        &Pile{},
+		reshuffle:   int(float64(blackjackDecks*52) * (1 - blackjackPenetration)),
+	}
+
+	for id := range l.Clients {
+		g.PlayerOrder = append(g.PlayerOrder, id)
+	}
+	sort.Slice(g.PlayerOrder, func(i, j int) bool {
+		return l.Clients[g.PlayerOrder[i]].JoinedAt < l.Clients[g.PlayerOrder[j]].JoinedAt
+	})
+	g.Betting = NewBetting(g.PlayerOrder, blackjackStack)
+
+	g.startRound()
+	return g
+}
+
+// The best total of the cards and whether an ace is being counted as 11
+func blackjackValue(cards Cards) (int, bool) {
+	total, aces := 0, 0
+	for _, c := range cards {
+		switch {
+		case c.Rank() == Ace:
+			total += 11
+			aces++
+		case c.Rank() >= Ten:
+			total += 10
+		default:
+			total += int(c.Rank())
+		}
+	}
+
+	for total > 21 && aces > 0 {
+		total -= 10
+		aces--
+	}
+
+	return total, aces > 0
+}
+
+// Two cards worth 21, not counting split hands
+func (h *BlackjackHand) natural() bool {
+	total, _ := blackjackValue(h.Cards)
+	return total == 21 && len(h.Cards) == 2 && !h.Split
+}
+
+func (h *BlackjackHand) busted() bool {
+	total, _ := blackjackValue(h.Cards)
+	return total > 21
+}
+
+func (g *BlackjackGame) draw() Card {
+	return Card(g.shoe.Draw(1)[0])
+}
+
+// Whether the player has enough chips for the smallest bet
+func (g *BlackjackGame) canPlay(player string) bool {
+	return g.Stacks[player] >= blackjackMinBet
+}
+
+func (g *BlackjackGame) startRound() {
+	if g.Round == blackjackRounds || !slices.ContainsFunc(g.PlayerOrder, g.canPlay) {
+		g.endGame()
+		return
+	}
+
+	g.Round++
+	g.NewHand(blackjackMinBet)
+	g.Phase = BlackjackPhaseBet
+	g.Hands = make(map[string][]*BlackjackHand)
+	g.Insurance = make(map[string]int)
+	g.Net = make(map[string]int)
+	g.Dealer = Cards{}
+	g.before = make(map[string]int)
+	for id, stack := range g.Stacks {
+		g.before[id] = stack
+	}
+
+	if len(*g.shoe) < g.reshuffle {
+		g.shoe = NewDeck(blackjackDecks, false)
+		g.shoe.Shuffle()
+	}
+	g.ShoeLeft = len(*g.shoe)
+}
+
+// Deals once everyone who can play has bet
+func (g *BlackjackGame) deal() {
+	for _, id := range g.PlayerOrder {
+		if hands, ok := g.Hands[id]; ok {
+			hands[0].Cards = Cards{g.draw(), g.draw()}
+		}
+	}
+
+	g.Dealer = Cards{g.draw()}
+	g.holeCard = g.draw()
+	g.ShoeLeft = len(*g.shoe)
+
+	if g.Dealer[0].Rank() == Ace {
+		g.Phase = BlackjackPhaseInsurance
+		return
+	}
+
+	g.peek()
+}
+
+// Checks the hole card for a blackjack, which ends the round straight away
+func (g *BlackjackGame) peek() {
+	total, _ := blackjackValue(Cards{g.Dealer[0], g.holeCard})
+	if total == 21 {
+		g.settle()
+		return
+	}
+
+	g.Phase = BlackjackPhasePlay
+	for _, hands := range g.Hands {
+		if hands[0].natural() {
+			hands[0].Done = true
+		}
+	}
+
+	g.checkDone()
+}
+
+// The hand the player is playing, nil if all of theirs are done
+func (g *BlackjackGame) active(player string) *BlackjackHand {
+	for _, h := range g.Hands[player] {
+		if !h.Done {
+			return h
+		}
+	}
+
+	return nil
+}
+
+// Settles the round once every hand is done
+func (g *BlackjackGame) checkDone() {
+	for id := range g.Hands {
+		if g.active(id) != nil {
+			return
+		}
+	}
+
+	g.settle()
+}
+
+// Plays the dealer's hand and pays out
+func (g *BlackjackGame) settle() {
+	g.Dealer = append(g.Dealer, g.holeCard)
+	dealer, _ := blackjackValue(g.Dealer)
+	dealerNatural := dealer == 21
+
+	// The dealer only draws if someone could still beat them
+	live := false
+	for _, hands := range g.Hands {
+		for _, h := range hands {
+			live = live || !h.busted() && !h.natural()
+		}
+	}
+
+	for live && !dealerNatural {
+		total, soft := blackjackValue(g.Dealer)
+		if total > 17 || total == 17 && (!soft || g.StandSoft17) {
+			break
+		}
+
+		g.Dealer = append(g.Dealer, g.draw())
+	}
+	g.ShoeLeft = len(*g.shoe)
+	dealer, _ = blackjackValue(g.Dealer)
+
+	for id, hands := range g.Hands {
+		if g.Insurance[id] > 0 && dealerNatural {
+			g.Pay(id, g.Insurance[id]*3)
+		}
+
+		for _, h := range hands {
+			total, _ := blackjackValue(h.Cards)
+			switch {
+			case h.natural() && !dealerNatural:
+				h.Result = BlackjackBlackjack
+				g.Pay(id, h.Bet+h.Bet*3/2)
+			case h.busted():
+				h.Result = BlackjackLose
+			case dealerNatural && !h.natural():
+				h.Result = BlackjackLose
+			case dealer > 21 || total > dealer:
+				h.Result = BlackjackWin
+				g.Pay(id, h.Bet*2)
+			case total == dealer:
+				h.Result = BlackjackPush
+				g.Pay(id, h.Bet)
+			default:
+				h.Result = BlackjackLose
+			}
+		}
+
+		g.Net[id] = g.Stacks[id] - g.before[id]
+	}
+
+	g.Phase = BlackjackPhaseSettle
+	g.lobby.after(blackjackResultDelay, g.nextRound)
+}
+
+// Starts the next round if the game is still being played. Runs on the lobby's goroutine
+func (g *BlackjackGame) nextRound() {
+	if g.lobby.game != g || g.Phase != BlackjackPhaseSettle {
+		return
+	}
+
+	// Wait for disconnected players to come back or an admin to resume the game
+	if g.lobby.onHold() {
+		g.lobby.after(blackjackResultDelay, g.nextRound)
+		return
+	}
+
+	g.startRound()
+	g.lobby.Sync()
+}
+
+func (g *BlackjackGame) endGame() {
+	g.Phase = BlackjackPhaseDone
+	g.Winners = append([]string{}, g.PlayerOrder...)
+	sort.SliceStable(g.Winners, func(i, j int) bool {
+		return g.Stacks[g.Winners[i]] > g.Stacks[g.Winners[j]]
+	})
+}
+
+func (g *BlackjackGame) bet(player string, data interface{}) error {
+	if _, ok := g.Hands[player]; ok {
+		return errors.New("you have already bet")
+	}
+
+	bet, err := g.Wager(player, data, blackjackMinBet, blackjackMaxBet)
+	if err != nil {
+		return err
+	}
+
+	g.Hands[player] = []*BlackjackHand{{Bet: bet}}
+	for _, id := range g.PlayerOrder {
+		if _, ok := g.Hands[id]; !ok && g.canPlay(id) {
+			return nil
+		}
+	}
+
+	g.deal()
+	return nil
+}
+
+func (g *BlackjackGame) insure(player string, insure bool) error {
+	if _, ok := g.Insurance[player]; ok {
+		return errors.New("you have already decided")
+	}
+
+	g.Insurance[player] = -1
+	if insure {
+		g.Insurance[player] = g.Put(player, g.Hands[player][0].Bet/2)
+	}
+
+	for id := range g.Hands {
+		if _, ok := g.Insurance[id]; !ok {
+			return nil
+		}
+	}
+
+	g.peek()
+	return nil
+}
+
+// Whether the hand can be split into two
+func (g *BlackjackGame) canSplit(player string, h *BlackjackHand) bool {
+	if len(h.Cards) != 2 || len(g.Hands[player]) >= blackjackMaxHands || g.Stacks[player] < h.Bet {
+		return false
+	}
+
+	a, _ := blackjackValue(h.Cards[:1])
+	b, _ := blackjackValue(h.Cards[1:])
+	return a == b
+}
+
+func (g *BlackjackGame) play(player string, move string) error {
+	h := g.active(player)
+	if h == nil {
+		return errors.New("your hands are done")
+	}
+
+	switch move {
+	case moveHit:
+		h.Cards = append(h.Cards, g.draw())
+	case moveStand:
+		h.Done = true
+	case moveDouble:
+		if len(h.Cards) != 2 || g.Stacks[player] < h.Bet {
+			return errors.New("you cannot double")
+		}
+
+		h.Bet += g.Put(player, h.Bet)
+		h.Cards = append(h.Cards, g.draw())
+		h.Done = true
+	case moveSplit:
+		if !g.canSplit(player, h) {
+			return errors.New("you cannot split")
+		}
+
+		other := &BlackjackHand{Cards: Cards{h.Cards[1], g.draw()}, Bet: g.Put(player, h.Bet), Split: true}
+		h.Cards = Cards{h.Cards[0], g.draw()}
+		h.Split = true
+
+		i := slices.Index(g.Hands[player], h)
+		g.Hands[player] = slices.Insert(g.Hands[player], i+1, other)
+
+		// Split aces only get one card each
+		if h.Cards[0].Rank() == Ace {
+			h.Done = true
+			other.Done = true
+		}
+	default:
+		return errors.New("unknown move")
+	}
+
+	if total, _ := blackjackValue(h.Cards); total >= 21 {
+		h.Done = true
+	}
+	g.ShoeLeft = len(*g.shoe)
+
+	g.checkDone()
+	return nil
+}
+
+// Name implements FreezableGame
+func (*BlackjackGame) Name(client *Client) string {
+	return "blackjack"
+}
+
+// State implements FreezableGame
+func (g *BlackjackGame) State(client *Client) interface{} {
+	return &BlackjackState{
+		BlackjackGame: *g,
+		Lobby:         g.lobby.State(client),
+	}
+}
+
+// LegalMoves implements FreezableGame. While betting, the data has the smallest and
+// largest bets allowed
+func (g *BlackjackGame) LegalMoves(client *Client) ([]string, map[string]interface{}) {
+	id := g.lobby.Client(client).ID
+
+	switch g.Phase {
+	case BlackjackPhaseDone:
+		return []string{MoveReturn}, nil
+	case BlackjackPhaseBet:
+		if _, ok := g.Hands[id]; ok || !g.canPlay(id) {
+			return nil, nil
+		}
+
+		max := blackjackMaxBet
+		if g.Stacks[id] < max {
+			max = g.Stacks[id]
+		}
+
+		return []string{moveBet}, map[string]interface{}{"min_bet": blackjackMinBet, "max_bet": max}
+	case BlackjackPhaseInsurance:
+		if _, ok := g.Insurance[id]; ok || g.Hands[id] == nil {
+			return nil, nil
+		}
+
+		moves := []string{moveNoInsurance}
+		if g.Stacks[id] >= g.Hands[id][0].Bet/2 {
+			moves = append(moves, moveInsurance)
+		}
+
+		return moves, nil
+	case BlackjackPhasePlay:
+		h := g.active(id)
+		if h == nil {
+			return nil, nil
+		}
+
+		moves := []string{moveHit, moveStand}
+		if len(h.Cards) == 2 && g.Stacks[id] >= h.Bet {
+			moves = append(moves, moveDouble)
+		}
+		if g.canSplit(id, h) {
+			moves = append(moves, moveSplit)
+		}
+
+		return moves, nil
+	}
+
+	return nil, nil
+}
+
+// ExecuteMoves implements FreezableGame
+func (g *BlackjackGame) ExecuteMoves(client *Client, moves []string, data interface{}) error {
+	id := g.lobby.Client(client).ID
+
+	switch g.Phase {
+	case BlackjackPhaseBet:
+		return g.bet(id, data)
+	case BlackjackPhaseInsurance:
+		return g.insure(id, moves[0] == moveInsurance)
+	case BlackjackPhasePlay:
+		return g.play(id, moves[0])
+	}
+
+	return errors.New("you cannot move right now")
+}
+
+// Inspect implements InspectableGame
+func (g *BlackjackGame) Inspect() interface{} {
+	return struct {
+		*BlackjackGame
+		HoleCard Card `json:"hole_card"`
+	}{g, g.holeCard}
+}
+
+// Basic strategy, more or less
+func (g *BlackjackGame) SelectMoves(client *Client, moves []string) []string {
+	switch moves[0] {
+	case moveBet, moveNoInsurance:
+		return []string{moves[0]}
+	}
+
+	h := g.active(g.lobby.Client(client).ID)
+	total, soft := blackjackValue(h.Cards)
+	up, _ := blackjackValue(g.Dealer[:1])
+
+	if slices.Contains(moves, moveSplit) && (h.Cards[0].Rank() == Ace || h.Cards[0].Rank() == Eight) {
+		return []string{moveSplit}
+	}
+
+	if slices.Contains(moves, moveDouble) && !soft && (total == 11 || total == 10 && up < 10) {
+		return []string{moveDouble}
+	}
+
+	switch {
+	case soft && total <= 17, total <= 11, total <= 16 && up >= 7:
+		return []string{moveHit}
+	}
+
+	return []string{moveStand}
+}
+
+// SelectData implements SmartDataGame by betting a tenth of the bot's chips
+func (g *BlackjackGame) SelectData(client *Client, moves []string) interface{} {
+	if moves[0] != moveBet {
+		return nil
+	}
+
+	bet := g.Stacks[g.lobby.Client(client).ID] / 10 / blackjackMinBet * blackjackMinBet
+	if bet < blackjackMinBet {
+		bet = blackjackMinBet
+	}
+	if bet > blackjackMaxBet {
+		bet = blackjackMaxBet
+	}
+
+	return map[string]interface{}{"amount": float64(bet)}
+}
+
+func init() {
+	registerGame(&GameData{
+		Create:     NewBlackjack,
+		Name:       "blackjack",
+		MinPlayers: 1,
+		MaxPlayers: 4,
+	})
+}
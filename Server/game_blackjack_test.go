@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Seats a alone at the table and has them bet 100 on a shoe stacked with the given cards,
+// which are dealt in order: two to a, then the dealer's up card and hole card
+func newTestBlackjack(t *testing.T, standSoft17 bool, shoe string) *BlackjackGame {
+	t.Helper()
+
+	l := newLobby("blackjack", "", &GameRules{BlackjackStandSoft17: standSoft17}, NewLocalPubSub())
+	l.Clients["a"] = &LobbyClient{ID: "a"}
+
+	g := NewBlackjack(l).(*BlackjackGame)
+	g.shoe = parseHand(t, shoe).Pile()
+	if err := g.bet("a", map[string]interface{}{"amount": float64(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	return g
+}
+
+func TestBlackjackValue(t *testing.T) {
+	tests := []struct {
+		hand  string
+		total int
+		soft  bool
+	}{
+		{"TS 7D", 17, false},
+		{"AS 6D", 17, true},
+		{"AS KD", 21, true},
+		{"AS AD", 12, true},
+		{"AS 6D TC", 17, false},
+		{"AS AD 9C", 21, true},
+		{"KS QD 5C", 25, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hand, func(t *testing.T) {
+			total, soft := blackjackValue(parseHand(t, tt.hand))
+			if total != tt.total || soft != tt.soft {
+				t.Errorf("blackjackValue() = %d, %v, want %d, %v", total, soft, tt.total, tt.soft)
+			}
+		})
+	}
+}
+
+func TestBlackjackSoft17(t *testing.T) {
+	tests := []struct {
+		name        string
+		standSoft17 bool
+		dealer      string // Up card and hole card, then what the dealer draws
+		want        string
+		result      BlackjackResult
+	}{
+		{"hits soft 17", false, "6H AC 4D", "6H AC 4D", BlackjackLose},
+		{"stands on soft 17", true, "6H AC 4D", "6H AC", BlackjackWin},
+		{"stands on hard 17", false, "TH 7C 4D", "TH 7C", BlackjackWin},
+		{"hits 16", true, "TH 6C 2D", "TH 6C 2D", BlackjackPush},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestBlackjack(t, tt.standSoft17, "TS 8D "+tt.dealer)
+			if err := g.play("a", moveStand); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := parseHand(t, tt.want); !reflect.DeepEqual(g.Dealer, got) {
+				t.Errorf("dealer ended with %v, want %v", g.Dealer, got)
+			}
+
+			if result := g.Hands["a"][0].Result; result != tt.result {
+				t.Errorf("18 against the dealer's %v is a %s, want a %s", g.Dealer, result, tt.result)
+			}
+		})
+	}
+}
+
+func TestBlackjackSplitAces(t *testing.T) {
+	// The second ace is dealt the king, then the first the five
+	g := newTestBlackjack(t, false, "AS AH 9C 8D KD 5C 2H")
+	if !reflect.DeepEqual(g.Hands["a"][0].Cards, parseHand(t, "AS AH")) || g.Phase != BlackjackPhasePlay {
+		t.Fatalf("dealt %v in phase %s", g.Hands["a"][0].Cards, g.Phase)
+	}
+
+	if err := g.play("a", moveSplit); err != nil {
+		t.Fatal(err)
+	}
+
+	hands := g.Hands["a"]
+	if len(hands) != 2 || !reflect.DeepEqual(hands[0].Cards, parseHand(t, "AS 5C")) || !reflect.DeepEqual(hands[1].Cards, parseHand(t, "AH KD")) {
+		t.Fatalf("split into %v and %v, want AS 5C and AH KD", hands[0].Cards, hands[1].Cards)
+	}
+
+	// Each ace only gets one card, so the round is settled straight away
+	if !hands[0].Done || !hands[1].Done || g.Phase != BlackjackPhaseSettle {
+		t.Fatalf("hands done %v and %v in phase %s, want both done and the round settled", hands[0].Done, hands[1].Done, g.Phase)
+	}
+
+	// 21 on a split hand is paid as a win, not a blackjack
+	if hands[0].Result != BlackjackLose || hands[1].Result != BlackjackWin {
+		t.Errorf("results %s and %s, want lose and win", hands[0].Result, hands[1].Result)
+	}
+
+	if g.Stacks["a"] != blackjackStack || g.Net["a"] != 0 {
+		t.Errorf("stack %d and net %d, want the stack back to %d", g.Stacks["a"], g.Net["a"], blackjackStack)
+	}
+}
+
+func TestBlackjackNatural(t *testing.T) {
+	tests := []struct {
+		name   string
+		shoe   string
+		result BlackjackResult
+		net    int
+	}{
+		{"pays 3 to 2", "AS KD 9C 7D", BlackjackBlackjack, 150},
+		{"pushes the dealer's blackjack", "AS KD TC AD", BlackjackPush, 0},
+		{"loses to the dealer's blackjack", "TS 9D KC AD", BlackjackLose, -100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestBlackjack(t, false, tt.shoe)
+			if g.Phase != BlackjackPhaseSettle {
+				t.Fatalf("phase %s, want the round settled straight after the deal", g.Phase)
+			}
+
+			if result := g.Hands["a"][0].Result; result != tt.result {
+				t.Errorf("result %s, want %s", result, tt.result)
+			}
+
+			if g.Net["a"] != tt.net || g.Stacks["a"] != blackjackStack+tt.net {
+				t.Errorf("net %d and stack %d, want net %d", g.Net["a"], g.Stacks["a"], tt.net)
+			}
+
+			// Nobody could beat the dealer, who doesn't draw
+			if len(g.Dealer) != 2 {
+				t.Errorf("dealer drew to %v", g.Dealer)
+			}
+		})
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// The whole deck is dealt and players take turns placing one to four cards face down on the
+// pile, claiming they are all of the rank whose turn it is: aces, then twos, and so on up to
+// kings and back to aces. Each card is its own move, so a play is sent as one packet with a
+// move per card. After every play anyone else can call cheat for a few seconds. The cards are
+// then turned over and whoever was wrong, the cheater or the caller, picks up the whole pile.
+// Players are ranked by when they get rid of their cards without being caught
+
+// How long everyone has to call cheat after a play
+const cheatWindow = 3 * time.Second
+
+const (
+	moveCheat = "cheat"
+)
+
+const cheatMaxCards = 4
+
+// How likely a bot is to call cheat on a claim of 1, 2, 3 or 4 cards it can't disprove
+var cheatCallOdds = []float64{0, 0.05, 0.1, 0.25, 0.5}
+
+// How likely a bot is to slip another card in with an honest play
+const cheatBluffOdds = 0.2
+
+type CheatClaim struct {
+	Player   string `json:"player"`
+	Rank     string `json:"rank"`
+	Count    int    `json:"count"`
+	Deadline int64  `json:"deadline"` // Unix milliseconds until which cheat can be called
+}
+
+type CheatReveal struct {
+	Player string `json:"player"` // Who made the claim
+	Caller string `json:"caller"`
+	Cards  Cards  `json:"cards"`
+	Lied   bool   `json:"lied"`
+}
+
+type Cheat struct {
+	CurrentPlayer int          `json:"current_player"` // -1 once the game is over
+	PlayerOrder   []string     `json:"player_order"`
+	Rank          string       `json:"rank"`   // What the current player has to claim
+	Pile          int          `json:"pile"`   // How many cards are face down in the pile
+	Claim         *CheatClaim  `json:"claim"`  // The last play while cheat can be called on it
+	Reveal        *CheatReveal `json:"reveal"` // The last time someone called cheat
+	Winners       []string     `json:"winners"`
+
+	lobby      *Lobby
+	hands      Hands
+	pile       *Pile
+	rank       Rank
+	played     Cards           // The cards of the last play
+	considered map[string]bool // Which bots have decided whether to call cheat on the claim
+}
+
+type CheatState struct {
+	Cheat
+	Hand       Cards          `json:"hand"`
+	OtherHands map[string]int `json:"other_hands"`
+	Lobby      *LobbyState    `json:"lobby"`
+}
+
+func NewCheat(l *Lobby) FreezableGame {
+	g := &Cheat{
+		Winners:    []string{},
+		lobby:      l,
+		hands:      Hands{},
+		pile:       &Pile{},
+		rank:       Ace,
+		considered: make(map[string]bool),
+	}
+
+	for id := range l.Clients {
+		g.PlayerOrder = append(g.PlayerOrder, id)
+		g.hands[id] = &Pile{}
+	}
+	sort.Slice(g.PlayerOrder, func(i, j int) bool {
+		return l.Clients[g.PlayerOrder[i]].JoinedAt < l.Clients[g.PlayerOrder[j]].JoinedAt
+	})
+
+	deck := NewDeck(1, false)
+	deck.Shuffle()
+	for i, c := range *deck {
+		g.hands[g.PlayerOrder[i%len(g.PlayerOrder)]].Insert([]int{c})
+	}
+
+	g.Rank = g.rank.String()
+	return g
+}
+
+// The rank claimed after the given one
+func cheatNextRank(r Rank) Rank {
+	switch r {
+	case Ace:
+		return Two
+	case King:
+		return Ace
+	}
+
+	return r + 1
+}
+
+func (g *Cheat) over() bool {
+	return g.CurrentPlayer == -1
+}
+
+// Passes the turn on to the next player who still has cards and the next rank. Once only one
+// player is left they place last
+func (g *Cheat) advance() {
+	playing := []string{}
+	for _, id := range g.PlayerOrder {
+		if len(*g.hands[id]) > 0 {
+			playing = append(playing, id)
+		}
+	}
+
+	if len(playing) <= 1 {
+		g.Winners = append(g.Winners, playing...)
+		g.CurrentPlayer = -1
+		return
+	}
+
+	g.rank = cheatNextRank(g.rank)
+	g.Rank = g.rank.String()
+	for {
+		g.CurrentPlayer = (g.CurrentPlayer + 1) % len(g.PlayerOrder)
+		if len(*g.hands[g.PlayerOrder[g.CurrentPlayer]]) > 0 {
+			return
+		}
+	}
+}
+
+// Ends the claim. A player who got rid of their last cards without being caught places next
+func (g *Cheat) closeClaim() {
+	player := g.Claim.Player
+	g.Claim = nil
+	if len(*g.hands[player]) == 0 {
+		g.Winners = append(g.Winners, player)
+	}
+
+	g.advance()
+}
+
+func (g *Cheat) place(player string, moves []string) error {
+	if g.Claim != nil {
+		return errors.New("wait for the last play to be called or accepted")
+	}
+
+	if len(moves) > cheatMaxCards {
+		return errors.New("you can place at most 4 cards")
+	}
+
+	cards := Cards{}
+	for _, m := range moves {
+		c, err := ParseCardCode(m)
+		if err != nil {
+			return err
+		}
+
+		if cards.Contains(c) {
+			return errors.New("you can only place each card once")
+		}
+
+		cards = append(cards, c)
+	}
+
+	// Bots skip the legal move check, so the cards have to be checked here
+	hand := g.hands[player].Cards()
+	for _, c := range cards {
+		if !hand.Contains(c) {
+			return errors.New("you can only place cards in your hand")
+		}
+	}
+
+	for _, c := range cards {
+		g.hands[player].Remove(c)
+		g.pile.Insert([]int{int(c)})
+	}
+
+	g.Pile = len(*g.pile)
+	g.played = cards
+	g.Reveal = nil
+	g.considered = make(map[string]bool)
+
+	claim := &CheatClaim{
+		Player:   player,
+		Rank:     g.Rank,
+		Count:    len(cards),
+		Deadline: time.Now().Add(cheatWindow).UnixMilli(),
+	}
+	g.Claim = claim
+
+	g.lobby.after(cheatWindow, func() { g.accept(claim) })
+	return nil
+}
+
+// Ends the claim if nobody called cheat in time. Runs on the lobby's goroutine
+func (g *Cheat) accept(claim *CheatClaim) {
+	if g.lobby.game != g || g.Claim != claim {
+		return
+	}
+
+	// Wait for disconnected players to come back or an admin to resume the game
+	if g.lobby.onHold() {
+		claim.Deadline = time.Now().Add(cheatWindow).UnixMilli()
+		g.lobby.after(cheatWindow, func() { g.accept(claim) })
+		return
+	}
+
+	g.closeClaim()
+	g.lobby.Sync()
+}
+
+func (g *Cheat) call(caller string) error {
+	if g.Claim == nil || g.Claim.Player == caller {
+		return errors.New("there is nothing to call cheat on")
+	}
+
+	rank, _ := ParseRankCode(g.Claim.Rank)
+	lied := false
+	for _, c := range g.played {
+		if c.Rank() != rank {
+			lied = true
+		}
+	}
+
+	loser := caller
+	if lied {
+		loser = g.Claim.Player
+	}
+
+	g.hands[loser].Insert(g.pile.Draw(len(*g.pile)))
+	g.Pile = 0
+	g.Reveal = &CheatReveal{
+		Player: g.Claim.Player,
+		Caller: caller,
+		Cards:  g.played,
+		Lied:   lied,
+	}
+
+	if lied {
+		g.lobby.Announce("%s caught %s cheating", g.lobby.Clients[caller].Name, g.lobby.Clients[loser].Name)
+	} else {
+		g.lobby.Announce("%s called cheat on %s, who was telling the truth", g.lobby.Clients[caller].Name, g.lobby.Clients[g.Claim.Player].Name)
+	}
+
+	g.closeClaim()
+	return nil
+}
+
+// Name implements FreezableGame
+func (*Cheat) Name(client *Client) string {
+	return "cheat"
+}
+
+// State implements FreezableGame
+func (g *Cheat) State(client *Client) interface{} {
+	c := g.lobby.Client(client)
+	hand := g.hands[c.ID].Cards()
+	hand.Sort()
+
+	return &CheatState{
+		Cheat:      *g,
+		Hand:       hand,
+		OtherHands: g.hands.StateHidden(c.ID),
+		Lobby:      g.lobby.State(client),
+	}
+}
+
+// LegalMoves implements FreezableGame
+func (g *Cheat) LegalMoves(client *Client) (moves []string, extra map[string]interface{}) {
+	if len(g.Winners) > 0 {
+		moves = append(moves, MoveReturn)
+	}
+
+	id := g.lobby.Client(client).ID
+	if g.over() || len(*g.hands[id]) == 0 {
+		return
+	}
+
+	if g.Claim != nil {
+		if g.Claim.Player != id {
+			moves = append(moves, moveCheat)
+		}
+
+		return
+	}
+
+	if g.PlayerOrder[g.CurrentPlayer] != id {
+		return
+	}
+
+	for _, c := range g.hands[id].Cards() {
+		moves = append(moves, c.String())
+	}
+
+	return
+}
+
+// ExecuteMoves implements FreezableGame. A play is one move per card
+func (g *Cheat) ExecuteMoves(client *Client, moves []string, data interface{}) error {
+	id := g.lobby.Client(client).ID
+	if g.over() {
+		return errors.New("the game is over")
+	}
+
+	if slices.Contains(moves, moveCheat) {
+		if len(moves) > 1 {
+			return errors.New("call cheat on its own")
+		}
+
+		return g.call(id)
+	}
+
+	if g.PlayerOrder[g.CurrentPlayer] != id {
+		return errors.New("it is not your turn")
+	}
+
+	return g.place(id, moves)
+}
+
+// Inspect implements InspectableGame
+func (g *Cheat) Inspect() interface{} {
+	return struct {
+		*Cheat
+		Hands     map[string]Cards `json:"hands"`
+		PileCards Cards            `json:"pile_cards"`
+		Played    Cards            `json:"played"`
+	}{g, g.hands.Cards(), g.pile.Cards(), g.played}
+}
+
+// SelectMoves implements SmartGame. The bot plays every card of the rank it has, sometimes
+// slipping in another, and bluffs with one or two cards when it has none. It always calls a
+// claim its own hand disproves, and otherwise calls bigger claims more often
+func (g *Cheat) SelectMoves(client *Client, moves []string) []string {
+	id := g.lobby.Client(client).ID
+	hand := g.hands[id].Cards()
+
+	if moves[0] == moveCheat {
+		if g.considered[id] {
+			return []string{}
+		}
+		g.considered[id] = true
+
+		rank, _ := ParseRankCode(g.Claim.Rank)
+		held := 0
+		for _, c := range hand {
+			if c.Rank() == rank {
+				held++
+			}
+		}
+
+		odds := cheatCallOdds[g.Claim.Count]
+		// Letting someone go out is worth the risk
+		if len(*g.hands[g.Claim.Player]) == 0 {
+			odds += 0.5
+		}
+
+		if held+g.Claim.Count > len(Suits) || rand.Float64() < odds {
+			return []string{moveCheat}
+		}
+
+		return []string{}
+	}
+
+	play := []string{}
+	others := Cards{}
+	for _, c := range hand {
+		if c.Rank() == g.rank {
+			play = append(play, c.String())
+		} else {
+			others = append(others, c)
+		}
+	}
+
+	rand.Shuffle(len(others), func(i, j int) {
+		others[i], others[j] = others[j], others[i]
+	})
+
+	bluffs := 0
+	switch {
+	case len(play) == 0:
+		bluffs = 1 + rand.Intn(2)
+	case rand.Float64() < cheatBluffOdds:
+		bluffs = 1
+	}
+
+	for i := 0; i < bluffs && i < len(others) && len(play) < cheatMaxCards; i++ {
+		play = append(play, others[i].String())
+	}
+
+	return play
+}
+
+func init() {
+	registerGame(&GameData{
+		Create:     NewCheat,
+		Name:       "cheat",
+		MinPlayers: 2,
+		MaxPlayers: 4,
+	})
+}
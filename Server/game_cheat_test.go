@@ -0,0 +1,166 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Seats a, b and c with the given hands and starts the game, with a to place aces
+func newTestCheat(t *testing.T, hands map[string]string) (*Cheat, map[string]*Client) {
+	t.Helper()
+
+	l := newLobby("cheat", "", nil, NewLocalPubSub())
+	clients := map[string]*Client{}
+	for i, id := range []string{"a", "b", "c"} {
+		// Closed, so syncing the lobby sends nothing
+		clients[id] = &Client{closed: true}
+		l.Clients[id] = &LobbyClient{Client: clients[id], ID: id, Name: id, JoinedAt: int64(i)}
+	}
+
+	g := NewCheat(l).(*Cheat)
+	l.game = g
+	for _, id := range g.PlayerOrder {
+		g.hands[id] = parseHand(t, hands[id]).Pile()
+	}
+
+	return g, clients
+}
+
+func TestCheatCall(t *testing.T) {
+	tests := []struct {
+		name   string
+		placed string
+		lied   bool
+		loser  string
+	}{
+		{"truth", "AC AD", false, "b"},
+		{"lie", "AC 2D", true, "a"},
+		{"one card of a lie", "AC AD AH 9S", true, "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, _ := newTestCheat(t, map[string]string{"a": "AC AD AH 2D 9S 3C", "b": "5H", "c": "6H"})
+			g.pile = parseHand(t, "KD QD").Pile()
+
+			if err := g.call("b"); err == nil {
+				t.Error("b called cheat before anything was placed")
+			}
+
+			placed := parseHand(t, tt.placed)
+			if err := g.place("a", strings.Fields(tt.placed)); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := g.call("a"); err == nil {
+				t.Error("a called cheat on themselves")
+			}
+
+			if err := g.call("b"); err != nil {
+				t.Fatal(err)
+			}
+
+			want := &CheatReveal{Player: "a", Caller: "b", Cards: placed, Lied: tt.lied}
+			if !reflect.DeepEqual(g.Reveal, want) {
+				t.Errorf("revealed %+v, want %+v", g.Reveal, want)
+			}
+
+			// The loser picks up the whole pile, including what was there before the claim
+			hand := g.hands[tt.loser].Cards()
+			for _, c := range append(parseHand(t, "KD QD"), placed...) {
+				if !hand.Contains(c) {
+					t.Errorf("%s didn't pick up %v", tt.loser, c)
+				}
+			}
+
+			if len(*g.pile) != 0 || g.Pile != 0 || g.Claim != nil {
+				t.Errorf("pile %v and claim %+v left after the call", g.pile.Cards(), g.Claim)
+			}
+
+			if g.PlayerOrder[g.CurrentPlayer] != "b" || g.rank != Two {
+				t.Errorf("%s is next to place %v, want b to place twos", g.PlayerOrder[g.CurrentPlayer], g.rank)
+			}
+		})
+	}
+}
+
+func TestCheatAccept(t *testing.T) {
+	g, _ := newTestCheat(t, map[string]string{"a": "AC 3D", "b": "2H 5H", "c": "6H"})
+
+	if err := g.place("a", []string{"AC"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// b calls before the window runs out and places next, so the first claim is stale when
+	// its timer fires
+	stale := g.Claim
+	if err := g.call("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.place("b", []string{"2H"}); err != nil {
+		t.Fatal(err)
+	}
+
+	current := g.Claim
+	g.accept(stale)
+	if g.Claim != current || g.PlayerOrder[g.CurrentPlayer] != "b" || g.rank != Two {
+		t.Fatalf("stale claim was accepted: claim %+v with %s to place %v", g.Claim, g.PlayerOrder[g.CurrentPlayer], g.rank)
+	}
+
+	g.accept(current)
+	if g.Claim != nil || g.PlayerOrder[g.CurrentPlayer] != "c" || g.rank != Three {
+		t.Fatalf("claim %+v with %s to place %v, want the claim accepted and c to place threes", g.Claim, g.PlayerOrder[g.CurrentPlayer], g.rank)
+	}
+
+	// c goes out unless someone calls
+	if err := g.place("c", []string{"6H"}); err != nil {
+		t.Fatal(err)
+	}
+
+	g.accept(g.Claim)
+	if !reflect.DeepEqual(g.Winners, []string{"c"}) {
+		t.Errorf("winners %v, want c", g.Winners)
+	}
+
+	// Nor is a claim from a game that has since ended
+	if err := g.place("a", []string{"3D"}); err != nil {
+		t.Fatal(err)
+	}
+
+	claim := g.Claim
+	g.lobby.game = nil
+	g.accept(claim)
+	if g.Claim != claim {
+		t.Error("claim was accepted after the game ended")
+	}
+}
+
+func TestCheatPlaceInvalid(t *testing.T) {
+	g, clients := newTestCheat(t, map[string]string{"a": "AC AD 2D", "b": "5H", "c": "6H"})
+
+	tests := []struct {
+		name  string
+		moves []string
+	}{
+		{"not in hand", []string{"AC", "AS"}},
+		{"twice", []string{"AC", "AC"}},
+		{"too many", []string{"AC", "AD", "2D", "5H", "6H"}},
+		{"not a card", []string{"AC", "ace"}},
+		{"cheat and cards", []string{"cheat", "AC"}},
+		{"cards and cheat", []string{"AC", "cheat"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := g.ExecuteMoves(clients["a"], tt.moves, nil); err == nil {
+				t.Errorf("placed %v", tt.moves)
+			}
+
+			if got := g.hands["a"].Cards(); !reflect.DeepEqual(got, parseHand(t, "AC AD 2D")) || len(*g.pile) != 0 || g.Claim != nil {
+				t.Errorf("hand %v, pile %v and claim %+v after a refused play", got, g.pile.Cards(), g.Claim)
+			}
+		})
+	}
+}
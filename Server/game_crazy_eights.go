@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Crazy Eights, or Mau-Mau, is Uno with a standard deck. Players take turns playing a card of
+// the same suit or rank as the top of the play pile, or drawing. Some ranks are special: by
+// default eights can be played on anything and name the next suit, sevens make the next
+// player draw two unless they play a seven too and aces skip the next player. Which ranks do
+// what is set by crazy_eights_specials in the config. Players are ranked by when they run out
+// of cards
+
+const (
+	crazyEightsHandSize        = 5
+	crazyEightsHeadsUpHandSize = 7
+)
+
+type CrazyEightsEffect string
+
+const (
+	// Can be played on anything but a draw, and the player names the suit to follow
+	CrazyEightsWild    CrazyEightsEffect = "wild"
+	CrazyEightsDrawTwo CrazyEightsEffect = "draw_two"
+	CrazyEightsSkip    CrazyEightsEffect = "skip"
+	CrazyEightsReverse CrazyEightsEffect = "reverse"
+)
+
+var crazyEightsSuitNames = map[Suit]string{
+	Clubs:    "clubs",
+	Diamonds: "diamonds",
+	Hearts:   "hearts",
+	Spades:   "spades",
+}
+
+// Parses rank=effect pairs like "8=wild", with ranks written as in card codes. Some rank has
+// to be wild, and some rank has to be plain so the play pile can start with it
+func parseCrazyEightsSpecials(pairs []string) (map[Rank]CrazyEightsEffect, error) {
+	specials := make(map[Rank]CrazyEightsEffect)
+	for _, pair := range pairs {
+		rank, effect, ok := strings.Cut(pair, "=")
+		rank = strings.ToUpper(strings.Replace(rank, "10", "T", 1))
+		r := strings.Index(rankCodes, rank)
+		if !ok || len(rank) != 1 || r < 0 {
+			return nil, fmt.Errorf("crazy eights special %q must look like 8=wild", pair)
+		}
+
+		switch e := CrazyEightsEffect(effect); e {
+		case CrazyEightsWild, CrazyEightsDrawTwo, CrazyEightsSkip, CrazyEightsReverse:
+			specials[Rank(r)+Two] = e
+		default:
+			return nil, fmt.Errorf("crazy eights effect %q must be wild, draw_two, skip or reverse", effect)
+		}
+	}
+
+	if len(specials) == len(rankCodes) {
+		return nil, errors.New("crazy_eights_specials must leave at least one rank plain")
+	}
+
+	for _, e := range specials {
+		if e == CrazyEightsWild {
+			return specials, nil
+		}
+	}
+
+	return nil, errors.New("crazy_eights_specials must make at least one rank wild")
+}
+
+type CrazyEights struct {
+	*Shedding
+	ChosenSuit Suit                         `json:"chosen_suit"` // Named by the last wild card
+	Specials   map[string]CrazyEightsEffect `json:"specials"`    // By rank code
+
+	lobby    *Lobby
+	specials map[Rank]CrazyEightsEffect
+	passes   int // How many players in a row couldn't play or draw
+}
+
+type CrazyEightsState struct {
+	CrazyEights
+	PlayPile   Cards          `json:"play_pile"`
+	Hand       Cards          `json:"hand"`
+	OtherHands map[string]int `json:"other_hands"`
+	Lobby      *LobbyState    `json:"lobby"`
+}
+
+func NewCrazyEights(l *Lobby) FreezableGame {
+	players := []string{}
+	for id := range l.Clients {
+		players = append(players, id)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return l.Clients[players[i]].JoinedAt < l.Clients[players[j]].JoinedAt
+	})
+
+	handSize := crazyEightsHandSize
+	if len(players) == 2 {
+		handSize = crazyEightsHeadsUpHandSize
+	}
+
+	p := NewDeck(1, false)
+	p.Shuffle()
+
+	g := &CrazyEights{
+		Shedding:   NewShedding(players, p, handSize),
+		ChosenSuit: NoSuit,
+		Specials:   make(map[string]CrazyEightsEffect),
+		lobby:      l,
+		specials:   l.rules.CrazyEightsSpecials,
+	}
+
+	for rank, effect := range g.specials {
+		g.Specials[rank.String()] = effect
+	}
+
+	// The first card can't be special
+	g.StartPile(func(card int) bool {
+		return g.effect(Card(card)) == ""
+	})
+
+	return g
+}
+
+func (g *CrazyEights) effect(c Card) CrazyEightsEffect {
+	return g.specials[c.Rank()]
+}
+
+func (g *CrazyEights) isPlayable(c Card) bool {
+	// A player who has to draw can only pass it on
+	if g.DrawNum > 0 {
+		return g.effect(c) == CrazyEightsDrawTwo
+	}
+
+	if g.effect(c) == CrazyEightsWild {
+		return true
+	}
+
+	if g.ChosenSuit != NoSuit {
+		return c.Suit() == g.ChosenSuit
+	}
+
+	top := Card(g.Top())
+	return c.Suit() == top.Suit() || c.Rank() == top.Rank()
+}
+
+// Whether everyone has been ranked
+func (g *CrazyEights) over() bool {
+	return g.Playing() == 0
+}
+
+// Ranks the players who still have cards by how many they have left
+func (g *CrazyEights) rankRest() {
+	rest := []string{}
+	for _, id := range g.PlayerOrder {
+		if len(*g.hands[id]) > 0 {
+			rest = append(rest, id)
+		}
+	}
+	sort.SliceStable(rest, func(i, j int) bool {
+		return len(*g.hands[rest[i]]) < len(*g.hands[rest[j]])
+	})
+
+	g.Winners = append(g.Winners, rest...)
+}
+
+func (g *CrazyEights) play(player string, move string) error {
+	code, suit, wild := strings.Cut(move, "_")
+	c, err := ParseCardCode(code)
+	if err != nil {
+		return err
+	}
+
+	if !g.hands[player].Cards().Contains(c) || !g.isPlayable(c) {
+		return errors.New("you cannot play that card")
+	}
+
+	named := strings.Index(suitCodes, suit)
+	if g.effect(c) == CrazyEightsWild && (!wild || len(suit) != 1 || named < 0) {
+		return errors.New("you must name a suit")
+	}
+
+	g.ChosenSuit = NoSuit
+	switch g.effect(c) {
+	case CrazyEightsWild:
+		g.ChosenSuit = Suit(named)
+		g.lobby.Announce("%s changed the suit to %s", g.lobby.Clients[player].Name, crazyEightsSuitNames[g.ChosenSuit])
+	case CrazyEightsDrawTwo:
+		g.DrawNum += 2
+	case CrazyEightsSkip:
+		g.NextPlayer()
+	case CrazyEightsReverse:
+		g.Reverse()
+	}
+
+	g.passes = 0
+	g.Discard(player, int(c))
+	if g.Playing() == 1 {
+		g.rankRest()
+	}
+
+	g.NextPlayer()
+	return nil
+}
+
+// Name implements FreezableGame
+func (*CrazyEights) Name(client *Client) string {
+	return "crazy_eights"
+}
+
+// State implements FreezableGame
+func (g *CrazyEights) State(client *Client) interface{} {
+	c := g.lobby.Client(client)
+	hand := g.hands[c.ID].Cards()
+	hand.Sort()
+
+	return &CrazyEightsState{
+		CrazyEights: *g,
+		PlayPile:    g.PlayPile.Cards(),
+		Hand:        hand,
+		OtherHands:  g.hands.StateHidden(c.ID),
+		Lobby:       g.lobby.State(client),
+	}
+}
+
+// LegalMoves implements FreezableGame
+func (g *CrazyEights) LegalMoves(client *Client) (moves []string, extra map[string]interface{}) {
+	if len(g.Winners) >= 1 {
+		moves = append(moves, MoveReturn)
+	}
+
+	c := g.lobby.Client(client)
+	if g.over() || g.Current() != c.ID {
+		return
+	}
+
+	// Once the cards run out players who can't play pass
+	if g.DrawNum > 0 || g.CanDraw() {
+		moves = append(moves, moveDraw)
+	} else {
+		moves = append(moves, movePass)
+	}
+
+	for _, card := range g.hands[c.ID].Cards() {
+		if !g.isPlayable(card) {
+			continue
+		}
+
+		if g.effect(card) == CrazyEightsWild {
+			for _, s := range Suits {
+				moves = append(moves, card.String()+"_"+s.String())
+			}
+		} else {
+			moves = append(moves, card.String())
+		}
+	}
+
+	return
+}
+
+// ExecuteMoves implements FreezableGame
+func (g *CrazyEights) ExecuteMoves(client *Client, moves []string, data interface{}) error {
+	c := g.lobby.Client(client)
+	if g.over() || g.Current() != c.ID {
+		return errors.New("it is not your turn")
+	}
+
+	switch moves[0] {
+	case moveDraw:
+		if g.DrawNum == 0 && !g.CanDraw() {
+			return errNoCardsLeft
+		}
+
+		g.passes = 0
+		// Forced draws are cut short when the cards run out
+		g.Draw(c.ID)
+	case movePass:
+		if g.DrawNum > 0 || g.CanDraw() {
+			return errors.New("you must draw")
+		}
+
+		g.passes++
+		// Nobody can do anything anymore
+		if g.passes >= g.Playing() {
+			g.rankRest()
+			return nil
+		}
+
+		g.NextPlayer()
+	default:
+		return g.play(c.ID, moves[0])
+	}
+
+	return nil
+}
+
+// Inspect implements InspectableGame
+func (g *CrazyEights) Inspect() interface{} {
+	return struct {
+		*CrazyEights
+		Hands    map[string]Cards `json:"hands"`
+		DrawPile Cards            `json:"draw_pile"`
+	}{g, g.hands.Cards(), g.drawPile.Cards()}
+}
+
+// SelectMoves implements SmartGame. The bot holds on to wild cards, plays the card that keeps
+// the most of its hand playable and names the suit it has most of
+func (g *CrazyEights) SelectMoves(client *Client, moves []string) []string {
+	hand := g.hands[g.lobby.Client(client).ID].Cards()
+	counts := make(map[Suit]int)
+	for _, c := range hand {
+		if g.effect(c) != CrazyEightsWild {
+			counts[c.Suit()]++
+		}
+	}
+
+	best := ""
+	bestScore := -1
+	for _, m := range moves {
+		code, suit, wild := strings.Cut(m, "_")
+		c, err := ParseCardCode(code)
+		if err != nil {
+			continue
+		}
+
+		score := 0
+		if wild {
+			score = counts[Suit(strings.Index(suitCodes, suit))]
+		} else {
+			// After playing the card the rest of its suit can follow
+			score = 20 + counts[c.Suit()]*2
+			if g.effect(c) != "" {
+				score++
+			}
+		}
+
+		if score > bestScore {
+			best = m
+			bestScore = score
+		}
+	}
+
+	if best != "" {
+		return []string{best}
+	}
+
+	// Draw or pass
+	return []string{moves[0]}
+}
+
+func init() {
+	registerGame(&GameData{
+		Create:     NewCrazyEights,
+		Name:       "crazy_eights",
+		MinPlayers: 2,
+		MaxPlayers: 4,
+	})
+}
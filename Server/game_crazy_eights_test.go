@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCrazyEightsSpecials(t *testing.T) {
+	tests := []struct {
+		name  string
+		pairs []string
+		want  map[Rank]CrazyEightsEffect
+	}{
+		{"default", DefaultConfig().CrazyEightsSpecials, map[Rank]CrazyEightsEffect{
+			Eight: CrazyEightsWild,
+			Seven: CrazyEightsDrawTwo,
+			Ace:   CrazyEightsSkip,
+		}},
+		{"only wild", []string{"2=wild"}, map[Rank]CrazyEightsEffect{Two: CrazyEightsWild}},
+		// The later pair for a rank wins
+		{"ten and lowercase", []string{"10=wild", "q=reverse", "T=skip", "j=wild"}, map[Rank]CrazyEightsEffect{
+			Ten:   CrazyEightsSkip,
+			Jack:  CrazyEightsWild,
+			Queen: CrazyEightsReverse,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCrazyEightsSpecials(tt.pairs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCrazyEightsSpecials(%v) = %v, want %v", tt.pairs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCrazyEightsSpecialsInvalid(t *testing.T) {
+	every := []string{}
+	for _, r := range rankCodes {
+		every = append(every, string(r)+"=wild")
+	}
+
+	tests := []struct {
+		name  string
+		pairs []string
+		err   string // Part of the error
+	}{
+		{"no effect", []string{"8"}, "must look like 8=wild"},
+		{"no rank", []string{"=wild"}, "must look like 8=wild"},
+		{"unknown rank", []string{"1=wild"}, "must look like 8=wild"},
+		{"two ranks", []string{"89=wild"}, "must look like 8=wild"},
+		{"card code", []string{"8S=wild"}, "must look like 8=wild"},
+		{"joker", []string{"BJ=wild"}, "must look like 8=wild"},
+		{"unknown effect", []string{"8=wild", "7=draw_four"}, "must be wild, draw_two, skip or reverse"},
+		{"effect case", []string{"8=Wild"}, "must be wild, draw_two, skip or reverse"},
+		{"empty", []string{}, "at least one rank wild"},
+		{"none wild", []string{"7=draw_two", "A=skip"}, "at least one rank wild"},
+		{"wild overridden", []string{"8=wild", "8=skip"}, "at least one rank wild"},
+		{"every rank special", every, "at least one rank plain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCrazyEightsSpecials(tt.pairs)
+			if err == nil {
+				t.Fatalf("parseCrazyEightsSpecials(%v) = %v, want an error", tt.pairs, got)
+			}
+
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error %q, want one about %q", err, tt.err)
+			}
+		})
+	}
+}
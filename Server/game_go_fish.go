@@ -0,0 +1,367 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// On their turn a player asks another player for a rank they hold themselves. If the other
+// player has any cards of that rank they hand them all over and the player asks again,
+// otherwise the player goes fishing in the pond and their turn ends, unless they fish the
+// rank they asked for. Four cards of a rank make a book, which is laid down as soon as it is
+// complete. Once all 13 books are down whoever has the most wins. Every ask goes in a public
+// log, since remembering who asked for what is most of the game
+
+const moveAsk = "ask"
+
+const (
+	goFishHandSize      = 7
+	goFishLargeHandSize = 5 // With 4 or more players
+	goFishBooks         = 13
+)
+
+type GoFishAsk struct {
+	Player string   `json:"player"`
+	Target string   `json:"target"`
+	Rank   string   `json:"rank"`
+	Got    int      `json:"got"`    // How many cards the target handed over, 0 for go fish
+	Caught bool     `json:"caught"` // Whether the player fished the rank they asked for
+	Books  []string `json:"books"`  // The ranks of the books the player completed with this ask
+}
+
+type GoFish struct {
+	CurrentPlayer int                 `json:"current_player"` // -1 once the game is over
+	PlayerOrder   []string            `json:"player_order"`
+	Books         map[string][]string `json:"books"` // The ranks of each player's books
+	Pond          int                 `json:"pond"`  // How many cards are left to fish
+	Log           []GoFishAsk         `json:"log"`
+	Winners       []string            `json:"winners"`
+
+	lobby *Lobby
+	pond  *Pile
+	hands Hands
+}
+
+type GoFishState struct {
+	GoFish
+	Hand       Cards          `json:"hand"`
+	OtherHands map[string]int `json:"other_hands"`
+	Lobby      *LobbyState    `json:"lobby"`
+}
+
+func NewGoFish(l *Lobby) FreezableGame {
+	g := &GoFish{
+		Books:   make(map[string][]string),
+		Log:     []GoFishAsk{},
+		Winners: []string{},
+		lobby:   l,
+		pond:    NewDeck(1, false),
+		hands:   Hands{},
+	}
+
+	for id := range l.Clients {
+		g.PlayerOrder = append(g.PlayerOrder, id)
+	}
+	sort.Slice(g.PlayerOrder, func(i, j int) bool {
+		return l.Clients[g.PlayerOrder[i]].JoinedAt < l.Clients[g.PlayerOrder[j]].JoinedAt
+	})
+
+	handSize := goFishHandSize
+	if len(g.PlayerOrder) >= 4 {
+		handSize = goFishLargeHandSize
+	}
+
+	g.pond.Shuffle()
+	for _, id := range g.PlayerOrder {
+		cards := Pile(g.pond.Draw(handSize))
+		g.hands[id] = &cards
+		g.Books[id] = []string{}
+		g.layBooks(id)
+	}
+
+	g.Pond = len(*g.pond)
+	return g
+}
+
+// Lays down every complete book in the player's hand. Returns their ranks
+func (g *GoFish) layBooks(player string) []string {
+	counts := make(map[Rank]int)
+	for _, c := range g.hands[player].Cards() {
+		counts[c.Rank()]++
+	}
+
+	books := []string{}
+	for rank, n := range counts {
+		if n < len(Suits) {
+			continue
+		}
+
+		g.hands[player].Filter(func(c Card) bool {
+			return c.Rank() != rank
+		})
+		books = append(books, rank.String())
+	}
+
+	sort.Strings(books)
+	g.Books[player] = append(g.Books[player], books...)
+	return books
+}
+
+func (g *GoFish) fish(player string) Card {
+	c := Card(g.pond.Draw(1)[0])
+	g.hands[player].Insert([]int{int(c)})
+	g.Pond = len(*g.pond)
+	return c
+}
+
+// How many cards of the rank the player holds
+func (g *GoFish) holding(player string, rank Rank) int {
+	n := 0
+	for _, c := range g.hands[player].Cards() {
+		if c.Rank() == rank {
+			n++
+		}
+	}
+
+	return n
+}
+
+// The other players who have cards to ask for
+func (g *GoFish) targets(player string) []string {
+	targets := []string{}
+	for _, id := range g.PlayerOrder {
+		if id != player && len(*g.hands[id]) > 0 {
+			targets = append(targets, id)
+		}
+	}
+
+	return targets
+}
+
+func (g *GoFish) booksDown() int {
+	n := 0
+	for _, books := range g.Books {
+		n += len(books)
+	}
+
+	return n
+}
+
+// Makes sure the current player can ask. Players without cards fish one if they can and are
+// skipped otherwise, and a player with nobody to ask fishes and passes the turn on
+func (g *GoFish) settleTurn() {
+	for tries := 0; tries <= 2*len(g.PlayerOrder); tries++ {
+		if g.booksDown() == goFishBooks {
+			g.endGame()
+			return
+		}
+
+		id := g.PlayerOrder[g.CurrentPlayer]
+		if len(*g.hands[id]) == 0 && len(*g.pond) > 0 {
+			g.fish(id)
+			g.layBooks(id)
+		}
+
+		if len(*g.hands[id]) > 0 && len(g.targets(id)) > 0 {
+			return
+		}
+
+		if len(*g.hands[id]) > 0 && len(*g.pond) > 0 {
+			g.fish(id)
+			g.layBooks(id)
+		}
+
+		g.CurrentPlayer = (g.CurrentPlayer + 1) % len(g.PlayerOrder)
+	}
+}
+
+// Ranks players by how many books they have
+func (g *GoFish) endGame() {
+	g.CurrentPlayer = -1
+	g.Winners = append([]string{}, g.PlayerOrder...)
+	sort.SliceStable(g.Winners, func(i, j int) bool {
+		return len(g.Books[g.Winners[i]]) > len(g.Books[g.Winners[j]])
+	})
+}
+
+func (g *GoFish) ask(player string, target string, rank Rank) error {
+	if target == player || g.hands[target] == nil || len(*g.hands[target]) == 0 {
+		return errors.New("you cannot ask that player")
+	}
+
+	if g.holding(player, rank) == 0 {
+		return errors.New("you can only ask for a rank you hold")
+	}
+
+	entry := GoFishAsk{Player: player, Target: target, Rank: rank.String()}
+	for _, c := range g.hands[target].Cards() {
+		if c.Rank() == rank {
+			g.hands[target].Remove(c)
+			g.hands[player].Insert([]int{int(c)})
+			entry.Got++
+		}
+	}
+
+	if entry.Got == 0 && len(*g.pond) > 0 {
+		entry.Caught = g.fish(player).Rank() == rank
+	}
+
+	entry.Books = g.layBooks(player)
+	g.Log = append(g.Log, entry)
+
+	if entry.Got == 0 && !entry.Caught {
+		g.CurrentPlayer = (g.CurrentPlayer + 1) % len(g.PlayerOrder)
+	}
+
+	g.settleTurn()
+	return nil
+}
+
+// Name implements FreezableGame
+func (*GoFish) Name(client *Client) string {
+	return "go_fish"
+}
+
+// State implements FreezableGame
+func (g *GoFish) State(client *Client) interface{} {
+	c := g.lobby.Client(client)
+	hand := g.hands[c.ID].Cards()
+	hand.Sort()
+
+	return &GoFishState{
+		GoFish:     *g,
+		Hand:       hand,
+		OtherHands: g.hands.StateHidden(c.ID),
+		Lobby:      g.lobby.State(client),
+	}
+}
+
+// LegalMoves implements FreezableGame. Asks are written ask_<player>_<rank>, like ask_<id>_Q
+func (g *GoFish) LegalMoves(client *Client) (moves []string, extra map[string]interface{}) {
+	if len(g.Winners) > 0 {
+		return []string{MoveReturn}, nil
+	}
+
+	id := g.lobby.Client(client).ID
+	if g.PlayerOrder[g.CurrentPlayer] != id {
+		return
+	}
+
+	ranks := []Rank{}
+	for _, c := range g.hands[id].Cards() {
+		if !slices.Contains(ranks, c.Rank()) {
+			ranks = append(ranks, c.Rank())
+		}
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		return ranks[i] < ranks[j]
+	})
+
+	for _, target := range g.targets(id) {
+		for _, rank := range ranks {
+			moves = append(moves, moveAsk+"_"+target+"_"+rank.String())
+		}
+	}
+
+	return
+}
+
+// Reads a move like ask_<id>_Q
+func parseAsk(move string) (string, Rank, error) {
+	parts := strings.Split(move, "_")
+	if len(parts) != 3 || parts[0] != moveAsk {
+		return "", 0, errors.New("unknown move")
+	}
+
+	rank, err := ParseRankCode(parts[2])
+	return parts[1], rank, err
+}
+
+// ExecuteMoves implements FreezableGame
+func (g *GoFish) ExecuteMoves(client *Client, moves []string, data interface{}) error {
+	id := g.lobby.Client(client).ID
+	if len(g.Winners) > 0 || g.PlayerOrder[g.CurrentPlayer] != id {
+		return errors.New("it is not your turn")
+	}
+
+	target, rank, err := parseAsk(moves[0])
+	if err != nil {
+		return err
+	}
+
+	return g.ask(id, target, rank)
+}
+
+// Inspect implements InspectableGame
+func (g *GoFish) Inspect() interface{} {
+	return struct {
+		*GoFish
+		Hands map[string]Cards `json:"hands"`
+		Pond  Cards            `json:"pond_cards"`
+	}{g, g.hands.Cards(), g.pond.Cards()}
+}
+
+// What the bot remembers from the log: the ranks each player is known to hold. Asking for a
+// rank shows the player has it, and handing cards over or going fish shows the target doesn't
+func (g *GoFish) memory() map[string]map[Rank]bool {
+	known := make(map[string]map[Rank]bool)
+	for _, id := range g.PlayerOrder {
+		known[id] = make(map[Rank]bool)
+	}
+
+	for _, entry := range g.Log {
+		rank, _ := ParseRankCode(entry.Rank)
+		known[entry.Player][rank] = true
+		known[entry.Target][rank] = false
+
+		for _, book := range entry.Books {
+			rank, _ := ParseRankCode(book)
+			for _, id := range g.PlayerOrder {
+				known[id][rank] = false
+			}
+		}
+	}
+
+	return known
+}
+
+// SelectMoves implements SmartGame. The bot asks someone it remembers asking for one of its
+// ranks, and otherwise asks a random player for the rank it holds the most of
+func (g *GoFish) SelectMoves(client *Client, moves []string) []string {
+	id := g.lobby.Client(client).ID
+	known := g.memory()
+
+	best := ""
+	bestScore := -1
+	for _, m := range moves {
+		target, rank, err := parseAsk(m)
+		if err != nil {
+			continue
+		}
+
+		score := g.holding(id, rank) + rand.Intn(2)
+		if known[target][rank] {
+			score += 10
+		}
+
+		if score > bestScore {
+			best = m
+			bestScore = score
+		}
+	}
+
+	return []string{best}
+}
+
+func init() {
+	registerGame(&GameData{
+		Create:     NewGoFish,
+		Name:       "go_fish",
+		MinPlayers: 2,
+		MaxPlayers: 4,
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+// Seats a, b and c with the given hands, books and pond. Books are space separated ranks
+func newTestGoFish(t *testing.T, hands map[string]string, books map[string]string, pond string) *GoFish {
+	t.Helper()
+
+	l := newLobby("go_fish", "", nil, NewLocalPubSub())
+	for i, id := range []string{"a", "b", "c"} {
+		l.Clients[id] = &LobbyClient{ID: id, JoinedAt: int64(i)}
+	}
+
+	g := NewGoFish(l).(*GoFish)
+	for _, id := range g.PlayerOrder {
+		g.hands[id] = parseHand(t, hands[id]).Pile()
+		g.Books[id] = strings.Fields(books[id])
+	}
+
+	g.pond = parseHand(t, pond).Pile()
+	g.Pond = len(*g.pond)
+	return g
+}
+
+// The twelve books other than twos, which is how far a game gets before its last book
+var goFishTwelveBooks = map[string]string{"a": "3 4 5 6", "b": "7 8 9 T", "c": "J Q K A"}
+
+func TestGoFishLayBooks(t *testing.T) {
+	g := newTestGoFish(t, map[string]string{"a": "9C KS 9D KD 9H 2C KH 9S KC 3D 3H 3S"}, map[string]string{"a": "5"}, "")
+
+	if books := g.layBooks("a"); !reflect.DeepEqual(books, []string{"9", "K"}) {
+		t.Errorf("laid %v, want 9 and K", books)
+	}
+
+	if want := []string{"5", "9", "K"}; !reflect.DeepEqual(g.Books["a"], want) {
+		t.Errorf("books %v, want %v", g.Books["a"], want)
+	}
+
+	// Three of a kind isn't a book
+	if got := g.hands["a"].Cards(); !reflect.DeepEqual(got, parseHand(t, "2C 3D 3H 3S")) {
+		t.Errorf("hand %v, want 2C 3D 3H 3S", got)
+	}
+
+	if books := g.layBooks("a"); len(books) != 0 {
+		t.Errorf("laid %v again", books)
+	}
+}
+
+func TestGoFishAskCompletesBook(t *testing.T) {
+	g := newTestGoFish(t, map[string]string{"a": "9C 9D 2C", "b": "9H 9S 4D", "c": "5H"}, nil, "6C")
+
+	if err := g.ask("a", "b", Nine); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := g.Log[len(g.Log)-1]
+	if entry.Got != 2 || !reflect.DeepEqual(entry.Books, []string{"9"}) {
+		t.Errorf("logged %+v, want two nines and a book of them", entry)
+	}
+
+	// Getting cards keeps the turn
+	if g.PlayerOrder[g.CurrentPlayer] != "a" || len(*g.hands["a"]) != 1 || len(*g.hands["b"]) != 1 {
+		t.Errorf("%s is next with hands %v", g.PlayerOrder[g.CurrentPlayer], g.hands.Cards())
+	}
+}
+
+func TestGoFishSettleTurn(t *testing.T) {
+	tests := []struct {
+		name    string
+		hands   map[string]string
+		books   map[string]string
+		pond    string
+		current string
+		want    string // Who is next, empty if the game ended
+		hand    string // Of whoever is next
+	}{
+		{
+			name:    "empty hand fishes",
+			hands:   map[string]string{"b": "7H"},
+			pond:    "5C 6D",
+			current: "a",
+			want:    "a",
+			hand:    "5C",
+		},
+		{
+			name:    "empty hand and pond is skipped",
+			hands:   map[string]string{"b": "7H 7D", "c": "7C"},
+			current: "a",
+			want:    "b",
+			hand:    "7H 7D",
+		},
+		{
+			name:    "empty hands are skipped",
+			hands:   map[string]string{"a": "7H 7D", "b": "7C"},
+			current: "c",
+			want:    "a",
+			hand:    "7H 7D",
+		},
+		{
+			name:    "last card fished",
+			hands:   map[string]string{"a": "2C 2D", "b": "2H"},
+			books:   goFishTwelveBooks,
+			pond:    "2S",
+			current: "c",
+			want:    "c",
+			hand:    "2S",
+		},
+		{
+			name:    "nobody to ask",
+			hands:   map[string]string{"a": "2C 2D 2H"},
+			books:   goFishTwelveBooks,
+			pond:    "2S",
+			current: "a",
+		},
+		{
+			name:    "every book down",
+			books:   map[string]string{"a": "2 3 4 5 6", "b": "7 8 9 T", "c": "J Q K A"},
+			current: "b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGoFish(t, tt.hands, tt.books, tt.pond)
+			g.CurrentPlayer = slices.Index(g.PlayerOrder, tt.current)
+			g.settleTurn()
+
+			if tt.want == "" {
+				if g.CurrentPlayer != -1 || g.Winners[0] != "a" {
+					t.Errorf("current player %d and winners %v, want the game over and won by a", g.CurrentPlayer, g.Winners)
+				}
+				return
+			}
+
+			if g.CurrentPlayer < 0 || g.PlayerOrder[g.CurrentPlayer] != tt.want {
+				t.Fatalf("current player %d, want %s", g.CurrentPlayer, tt.want)
+			}
+
+			if got := g.hands[tt.want].Cards(); !reflect.DeepEqual(got, parseHand(t, tt.hand)) {
+				t.Errorf("%s holds %v, want %s", tt.want, got, tt.hand)
+			}
+
+			if g.Pond != len(*g.pond) {
+				t.Errorf("pond is %d, but has %d cards", g.Pond, len(*g.pond))
+			}
+		})
+	}
+}
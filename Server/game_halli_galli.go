@@ -170,6 +170,14 @@ func (game *HG) State(client *Client) interface{} {
 	}
 }
 
+// Inspect implements InspectableGame
+func (game *HG) Inspect() interface{} {
+	return struct {
+		*HG
+		Hands Hands `json:"hands"`
+	}{game, game.hands}
+}
+
 func init() {
 	registerGame(&GameData{
 		Create:     NewHG,
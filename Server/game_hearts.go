@@ -0,0 +1,379 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// Four players try to avoid taking hearts (1 point each) and the queen of spades (13 points).
+// Before every round each player passes three cards left, right or across, and every fourth
+// round nobody passes. Whoever takes every point shoots the moon and everyone else gets 26
+// instead. Once someone reaches 100 points the lowest score wins
+
+const (
+	movePass = "pass"
+)
+
+const (
+	heartsPassSize  = 3
+	heartsMoon      = 26
+	heartsGameScore = 100
+)
+
+// Before TrickPhasePlay on rounds where cards are passed
+const HeartsPhasePass TrickPhase = "pass"
+
+type HeartsPass string
+
+// In the order they rotate through
+const (
+	HeartsPassLeft   HeartsPass = "left"
+	HeartsPassRight  HeartsPass = "right"
+	HeartsPassAcross HeartsPass = "across"
+	HeartsPassHold   HeartsPass = "hold"
+)
+
+var heartsPasses = []HeartsPass{HeartsPassLeft, HeartsPassRight, HeartsPassAcross, HeartsPassHold}
+
+// How many seats to the left the cards go
+var heartsPassOffsets = map[HeartsPass]int{
+	HeartsPassLeft:   1,
+	HeartsPassRight:  -1,
+	HeartsPassAcross: 2,
+	HeartsPassHold:   0,
+}
+
+var (
+	twoOfClubs    = NewCard(Two, Clubs)
+	queenOfSpades = NewCard(Queen, Spades)
+)
+
+type HeartsGame struct {
+	*TrickGame
+	Pass         HeartsPass      `json:"pass"`
+	Passed       map[string]bool `json:"passed"`        // Who has chosen their cards to pass
+	HeartsBroken bool            `json:"hearts_broken"` // Whether hearts may be led
+	Moon         string          `json:"moon"`          // Who shot the moon last round, if anyone
+
+	passes   map[string]Cards // The cards each player is passing
+	received map[string]Cards // The cards each player was passed this round
+}
+
+type HeartsState struct {
+	TrickState
+	Pass         HeartsPass      `json:"pass"`
+	Passed       map[string]bool `json:"passed"`
+	HeartsBroken bool            `json:"hearts_broken"`
+	Moon         string          `json:"moon"`
+	Passing      Cards           `json:"passing"`
+	Received     Cards           `json:"received"`
+}
+
+func NewHearts(l *Lobby) FreezableGame {
+	g := &HeartsGame{}
+	g.TrickGame = NewTrickGame(l, g, true)
+	g.StartRound()
+	return g
+}
+
+// Deck implements TrickRules
+func (*HeartsGame) Deck() *Pile {
+	return NewDeck(1, false)
+}
+
+// Trump implements TrickRules
+func (*HeartsGame) Trump() Suit {
+	return NoSuit
+}
+
+// FirstLeader implements TrickRules
+func (g *HeartsGame) FirstLeader() string {
+	return g.Holder(twoOfClubs)
+}
+
+// RoundStarted implements TrickRoundStarter
+func (g *HeartsGame) RoundStarted() {
+	g.Pass = heartsPasses[(g.Round-1)%len(heartsPasses)]
+	g.Passed = make(map[string]bool)
+	g.HeartsBroken = false
+	g.passes = make(map[string]Cards)
+	g.received = make(map[string]Cards)
+
+	if g.Pass != HeartsPassHold {
+		g.Phase = HeartsPhasePass
+	}
+}
+
+func heartsPoints(c Card) int {
+	if c == queenOfSpades {
+		return 13
+	}
+
+	if c.Suit() == Hearts {
+		return 1
+	}
+
+	return 0
+}
+
+// Allowed implements TrickRules
+func (g *HeartsGame) Allowed(player string, c Card) bool {
+	if g.FirstTrick() {
+		if g.Leading() {
+			return c == twoOfClubs
+		}
+
+		// Nobody may bleed points on the first trick
+		return heartsPoints(c) == 0
+	}
+
+	if g.Leading() && c.Suit() == Hearts {
+		return g.HeartsBroken
+	}
+
+	return true
+}
+
+// TrickDone implements TrickRules
+func (g *HeartsGame) TrickDone(trick *Trick) {
+	for _, p := range trick.Plays {
+		if p.Card.Suit() == Hearts {
+			g.HeartsBroken = true
+		}
+	}
+}
+
+// ScoreRound implements TrickRules
+func (g *HeartsGame) ScoreRound() map[string]int {
+	points := make(map[string]int)
+	for _, id := range g.PlayerOrder {
+		points[id] = 0
+	}
+
+	for _, trick := range g.Tricks {
+		for _, p := range trick.Plays {
+			points[trick.Winner] += heartsPoints(p.Card)
+		}
+	}
+
+	g.Moon = ""
+	for id, p := range points {
+		if p == heartsMoon {
+			g.Moon = id
+		}
+	}
+
+	if g.Moon != "" {
+		for id := range points {
+			points[id] = heartsMoon
+		}
+		points[g.Moon] = 0
+	}
+
+	return points
+}
+
+// GameOver implements TrickRules
+func (g *HeartsGame) GameOver() bool {
+	for _, s := range g.Scores {
+		if s >= heartsGameScore {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sets aside the cards the player is passing. Once everyone has chosen, the cards are
+// handed over and the holder of the two of clubs leads
+func (g *HeartsGame) passCards(player string, cards Cards) error {
+	if g.Passed[player] {
+		return errors.New("you have already passed")
+	}
+
+	if len(cards) != heartsPassSize {
+		return errors.New("you must pass three cards")
+	}
+
+	hand := g.Hand(player)
+	for i, c := range cards {
+		if !hand.Contains(c) || cards[:i].Contains(c) {
+			return errors.New("you can only pass cards in your hand")
+		}
+	}
+
+	for _, c := range cards {
+		g.hands[player].Remove(c)
+	}
+	g.passes[player] = cards
+	g.Passed[player] = true
+
+	if len(g.Passed) < len(g.PlayerOrder) {
+		return nil
+	}
+
+	for id, cards := range g.passes {
+		to := g.LeftOf(id, heartsPassOffsets[g.Pass])
+		g.received[to] = cards
+		for _, c := range cards {
+			g.hands[to].Insert([]int{int(c)})
+		}
+	}
+
+	g.Phase = TrickPhasePlay
+	g.CurrentPlayer = g.seat(g.FirstLeader())
+	return nil
+}
+
+// Name implements FreezableGame
+func (*HeartsGame) Name(client *Client) string {
+	return "hearts"
+}
+
+// State implements FreezableGame
+func (g *HeartsGame) State(client *Client) interface{} {
+	id := g.lobby.Client(client).ID
+	return &HeartsState{
+		TrickState:   g.TrickState(client),
+		Pass:         g.Pass,
+		Passed:       g.Passed,
+		HeartsBroken: g.HeartsBroken,
+		Moon:         g.Moon,
+		Passing:      g.passes[id],
+		Received:     g.received[id],
+	}
+}
+
+// LegalMoves implements FreezableGame
+func (g *HeartsGame) LegalMoves(client *Client) ([]string, map[string]interface{}) {
+	if g.Phase == HeartsPhasePass {
+		if g.Passed[g.lobby.Client(client).ID] {
+			return nil, nil
+		}
+
+		return []string{movePass}, nil
+	}
+
+	return g.TrickGame.LegalMoves(client)
+}
+
+// ExecuteMoves implements FreezableGame
+func (g *HeartsGame) ExecuteMoves(client *Client, moves []string, data interface{}) error {
+	if g.Phase == HeartsPhasePass {
+		cards, err := CardsFromData(data, "cards")
+		if err != nil {
+			return err
+		}
+
+		return g.passCards(g.lobby.Client(client).ID, cards)
+	}
+
+	return g.TrickGame.ExecuteMoves(client, moves, data)
+}
+
+// Inspect implements InspectableGame
+func (g *HeartsGame) Inspect() interface{} {
+	return struct {
+		*HeartsGame
+		Hands    map[string]Cards `json:"hands"`
+		Passes   map[string]Cards `json:"passes"`
+		Received map[string]Cards `json:"received"`
+	}{g, g.hands.Cards(), g.passes, g.received}
+}
+
+// How badly a bot wants to get rid of the card
+func heartsDanger(c Card) int {
+	switch {
+	case c == queenOfSpades:
+		return 100
+	case c.Suit() == Spades && c.Rank() > Queen:
+		return 50 + int(c.Rank())
+	case c.Suit() == Hearts:
+		return 20 + int(c.Rank())
+	}
+
+	return int(c.Rank())
+}
+
+// Sorts the cards from most to least dangerous
+func heartsSortByDanger(cards Cards) {
+	sort.SliceStable(cards, func(i, j int) bool {
+		return heartsDanger(cards[i]) > heartsDanger(cards[j])
+	})
+}
+
+func (g *HeartsGame) SelectMoves(client *Client, moves []string) []string {
+	if moves[0] == movePass {
+		return []string{moves[0]}
+	}
+
+	return []string{g.botCard(g.lobby.Client(client).ID).String()}
+}
+
+// SelectData implements SmartDataGame by passing the most dangerous cards
+func (g *HeartsGame) SelectData(client *Client, moves []string) interface{} {
+	if moves[0] != movePass {
+		return nil
+	}
+
+	hand := g.Hand(g.lobby.Client(client).ID)
+	heartsSortByDanger(hand)
+
+	codes := []interface{}{}
+	for _, c := range hand[:heartsPassSize] {
+		codes = append(codes, c.String())
+	}
+
+	return map[string]interface{}{"cards": codes}
+}
+
+// Picks the card that takes the fewest points
+func (g *HeartsGame) botCard(player string) Card {
+	playable := g.Playable(player)
+
+	// Lead the lowest card, keeping the queen of spades and hearts back
+	if g.Leading() {
+		heartsSortByDanger(playable)
+		return playable[len(playable)-1]
+	}
+
+	// Void in the suit led, so dump whatever is most dangerous
+	if playable[0].Suit() != g.Trick.Led {
+		heartsSortByDanger(playable)
+		return playable[0]
+	}
+
+	card := g.LowestLoser(player)
+	if !g.wouldWin(card) {
+		return card
+	}
+
+	// Winning is unavoidable. The last player to a trick without points might as well
+	// spend their highest card
+	if len(g.Trick.Plays) == len(g.PlayerOrder)-1 {
+		points := 0
+		for _, p := range g.Trick.Plays {
+			points += heartsPoints(p.Card)
+		}
+
+		if points == 0 {
+			playable.SortByRank()
+			for _, c := range playable {
+				if c != queenOfSpades {
+					return c
+				}
+			}
+		}
+	}
+
+	return card
+}
+
+func init() {
+	registerGame(&GameData{
+		Create:     NewHearts,
+		Name:       "hearts",
+		MinPlayers: 4,
+		MaxPlayers: 4,
+	})
+}
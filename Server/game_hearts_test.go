@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Deals a game of hearts to d and a to c, skips passing and replaces the hands with the given
+// ones, which are space separated card codes. Whoever holds the two of clubs leads
+func newTestHearts(t *testing.T, hands map[string]string) *HeartsGame {
+	t.Helper()
+
+	l := newLobby("hearts", "", nil, NewLocalPubSub())
+	for i, id := range []string{"d", "a", "b", "c"} {
+		l.Clients[id] = &LobbyClient{ID: id, JoinedAt: int64(i)}
+	}
+
+	g := NewHearts(l).(*HeartsGame)
+	setTrickHands(t, g.TrickGame, hands)
+	g.Phase = TrickPhasePlay
+	g.CurrentPlayer = g.seat(g.FirstLeader())
+	return g
+}
+
+// A finished trick of cards played by a, b, c and d
+func heartsTrick(t *testing.T, winner string, codes string) *Trick {
+	t.Helper()
+
+	trick := &Trick{Winner: winner}
+	for i, c := range parseHand(t, codes) {
+		trick.Plays = append(trick.Plays, TrickPlay{Player: []string{"a", "b", "c", "d"}[i], Card: c})
+	}
+
+	return trick
+}
+
+func TestHeartsFirstTrick(t *testing.T) {
+	g := newTestHearts(t, map[string]string{
+		"a": "3C 2H 5D",
+		"b": "QS 4H 7D",
+		"c": "2C 9C 8D",
+		"d": "QH KH AH",
+	})
+
+	if g.Current() != "c" {
+		t.Fatalf("%s leads, want c with the two of clubs", g.Current())
+	}
+
+	if got := g.Playable("c"); !reflect.DeepEqual(got, parseHand(t, "2C")) {
+		t.Errorf("c may lead %v, want only 2C", got)
+	}
+
+	playTrick(t, g.TrickGame, "2C")
+
+	// Void in clubs, but points still can't be dumped while there is anything else
+	if got := g.Playable("d"); !reflect.DeepEqual(got, parseHand(t, "QH KH AH")) {
+		t.Errorf("d may play %v, want every heart since they hold nothing else", got)
+	}
+
+	playTrick(t, g.TrickGame, "QH 3C")
+	if got := g.Playable("b"); !reflect.DeepEqual(got, parseHand(t, "7D")) {
+		t.Errorf("b may play %v, want only 7D", got)
+	}
+
+	if err := g.Play("b", queenOfSpades); err == nil {
+		t.Error("b dumped the queen of spades on the first trick")
+	}
+
+	playTrick(t, g.TrickGame, "7D")
+	if !g.HeartsBroken {
+		t.Error("hearts weren't broken by the heart d had to play")
+	}
+
+	// After the first trick points may be dumped
+	g.Trick = &Trick{Plays: []TrickPlay{{Player: "c", Card: NewCard(Nine, Clubs)}}, Led: Clubs}
+	g.CurrentPlayer = g.seat("b")
+	if got := g.Playable("b"); !reflect.DeepEqual(got, parseHand(t, "QS 4H")) {
+		t.Errorf("b may play %v after the first trick, want QS 4H", got)
+	}
+}
+
+func TestHeartsLeadHearts(t *testing.T) {
+	g := newTestHearts(t, map[string]string{"a": "2C 3H 4D", "b": "5C", "c": "6C", "d": "7C"})
+	g.Tricks = []*Trick{heartsTrick(t, "a", "2C 5C 6C 7C")}
+	g.Trick = g.Tricks[0]
+	g.CurrentPlayer = g.seat("a")
+
+	if got := g.Playable("a"); !reflect.DeepEqual(got, parseHand(t, "2C 4D")) {
+		t.Errorf("a may lead %v before hearts are broken, want 2C 4D", got)
+	}
+
+	g.HeartsBroken = true
+	if got := g.Playable("a"); !reflect.DeepEqual(got, parseHand(t, "2C 3H 4D")) {
+		t.Errorf("a may lead %v once hearts are broken, want their whole hand", got)
+	}
+}
+
+func TestHeartsScoreRound(t *testing.T) {
+	tests := []struct {
+		name   string
+		tricks []*Trick
+		want   map[string]int
+		moon   string
+	}{
+		{
+			name: "points go to the winner",
+			tricks: []*Trick{
+				heartsTrick(t, "a", "2C 3H QS 4C"),
+				heartsTrick(t, "b", "5D 6D 7D 8H"),
+			},
+			want: map[string]int{"a": 14, "b": 1, "c": 0, "d": 0},
+		},
+		{
+			name: "shooting the moon",
+			tricks: []*Trick{
+				heartsTrick(t, "c", "2H 3H 4H 5H"),
+				heartsTrick(t, "c", "6H 7H 8H 9H"),
+				heartsTrick(t, "c", "TH JH QH KH"),
+				heartsTrick(t, "c", "AH QS 2C 3C"),
+			},
+			want: map[string]int{"a": 26, "b": 26, "c": 0, "d": 26},
+			moon: "c",
+		},
+		{
+			name: "one heart short of the moon",
+			tricks: []*Trick{
+				heartsTrick(t, "c", "2H 3H 4H 5H"),
+				heartsTrick(t, "c", "6H 7H 8H 9H"),
+				heartsTrick(t, "c", "TH JH QH KH"),
+				heartsTrick(t, "c", "2D QS 2C 3C"),
+				heartsTrick(t, "a", "AH 4C 5C 6C"),
+			},
+			want: map[string]int{"a": 1, "b": 0, "c": 25, "d": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestHearts(t, map[string]string{})
+			g.Moon = "b"
+			g.Tricks = tt.tricks
+
+			if got := g.ScoreRound(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ScoreRound() = %v, want %v", got, tt.want)
+			}
+
+			if g.Moon != tt.moon {
+				t.Errorf("%q shot the moon, want %q", g.Moon, tt.moon)
+			}
+		})
+	}
+}
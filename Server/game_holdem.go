@@ -0,0 +1,438 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// No-limit Texas Hold'em. Everyone starts with the same stack and plays hands until one player
+// has every chip. Blinds double every few hands so games finish. Each street is a betting round
+// of Betting
+
+const (
+	holdemStartingStack = 1000
+	holdemSmallBlind    = 10
+	holdemBlindsEvery   = 10 // Hands between blinds doubling
+	holdemShowdownDelay = 5 * time.Second
+)
+
+type HoldemPhase string
+
+const (
+	HoldemPhasePreflop  HoldemPhase = "preflop"
+	HoldemPhaseFlop     HoldemPhase = "flop"
+	HoldemPhaseTurn     HoldemPhase = "turn"
+	HoldemPhaseRiver    HoldemPhase = "river"
+	HoldemPhaseShowdown HoldemPhase = "showdown" // The hand is over and the next is about to be dealt
+	HoldemPhaseDone     HoldemPhase = "done"     // The game is over
+)
+
+// The streets in order, with how many cards are dealt to the board before each
+var holdemStreets = []struct {
+	phase HoldemPhase
+	cards int
+}{
+	{HoldemPhasePreflop, 0},
+	{HoldemPhaseFlop, 3},
+	{HoldemPhaseTurn, 1},
+	{HoldemPhaseRiver, 1},
+}
+
+type HoldemResult struct {
+	Player string `json:"player"`
+	Won    int    `json:"won"`
+	Hand   string `json:"hand,omitempty"` // Empty if everyone else folded
+}
+
+type HoldemGame struct {
+	*Betting
+	Phase         HoldemPhase      `json:"phase"`
+	PlayerOrder   []string         `json:"player_order"`   // Seating, clockwise, including busted players
+	Dealer        int              `json:"dealer"`         // Index in PlayerOrder
+	CurrentPlayer int              `json:"current_player"` // Index in PlayerOrder, -1 if nobody can act
+	HandNumber    int              `json:"hand_number"`
+	SmallBlind    int              `json:"small_blind"`
+	BigBlind      int              `json:"big_blind"`
+	Board         Cards            `json:"board"`
+	Results       []HoldemResult   `json:"results"` // What each player won at the end of the hand
+	Shown         map[string]Cards `json:"shown"`   // The hole cards shown at showdown
+	Eliminated    []string         `json:"eliminated"`
+	Winners       []string         `json:"winners"`
+
+	lobby *Lobby
+	deck  *Pile
+	holes map[string]Cards
+}
+
+type HoldemState struct {
+	HoldemGame
+	Hole  Cards       `json:"hole"`
+	Lobby *LobbyState `json:"lobby"`
+}
+
+func NewHoldem(l *Lobby) FreezableGame {
+	g := &HoldemGame{
+		Eliminated: []string{},
+		Winners:    []string{},
+		lobby:      l,
+	}
+
+	for id := range l.Clients {
+		g.PlayerOrder = append(g.PlayerOrder, id)
+	}
+	sort.Slice(g.PlayerOrder, func(i, j int) bool {
+		return l.Clients[g.PlayerOrder[i]].JoinedAt < l.Clients[g.PlayerOrder[j]].JoinedAt
+	})
+	g.Betting = NewBetting(g.PlayerOrder, holdemStartingStack)
+
+	g.Dealer = rand.Intn(len(g.PlayerOrder))
+	g.startHand()
+	return g
+}
+
+// Whether the player still has chips
+func (g *HoldemGame) seated(i int) bool {
+	return g.Stacks[g.PlayerOrder[i]] > 0
+}
+
+// The next seat after i, clockwise, for which ok returns true. -1 if there is none
+func (g *HoldemGame) nextSeat(i int, ok func(i int) bool) int {
+	n := len(g.PlayerOrder)
+	for offset := 1; offset <= n; offset++ {
+		if j := (i + offset) % n; ok(j) {
+			return j
+		}
+	}
+
+	return -1
+}
+
+func (g *HoldemGame) startHand() {
+	// Players who ran out of chips last hand are out of the game
+	for _, id := range g.PlayerOrder {
+		if g.Stacks[id] == 0 && !slices.Contains(g.Eliminated, id) {
+			g.Eliminated = append(g.Eliminated, id)
+		}
+	}
+
+	if len(g.PlayerOrder)-len(g.Eliminated) <= 1 {
+		g.endGame()
+		return
+	}
+
+	g.HandNumber++
+	g.SmallBlind = holdemSmallBlind << ((g.HandNumber - 1) / holdemBlindsEvery)
+	g.BigBlind = g.SmallBlind * 2
+	g.NewHand(g.BigBlind)
+	g.Board = Cards{}
+	g.Results = []HoldemResult{}
+	g.Shown = make(map[string]Cards)
+	g.holes = make(map[string]Cards)
+
+	g.deck = NewDeck(1, false)
+	g.deck.Shuffle()
+	for _, id := range g.PlayerOrder {
+		if g.Stacks[id] > 0 {
+			g.holes[id] = g.draw(2)
+		}
+	}
+
+	g.Dealer = g.nextSeat(g.Dealer, g.seated)
+	small := g.nextSeat(g.Dealer, g.seated)
+	// Heads up, the dealer posts the small blind
+	if len(g.holes) == 2 {
+		small = g.Dealer
+	}
+	big := g.nextSeat(small, g.seated)
+
+	g.Phase = HoldemPhasePreflop
+	g.Blind(g.PlayerOrder[small], g.SmallBlind)
+	g.Blind(g.PlayerOrder[big], g.BigBlind)
+	g.CurrentPlayer = big
+	g.advance()
+}
+
+func (g *HoldemGame) draw(n int) Cards {
+	drawn := Pile(g.deck.Draw(n))
+	return drawn.Cards()
+}
+
+// Whether the player still has to act this street
+func (g *HoldemGame) needsAction(i int) bool {
+	return g.NeedsAction(g.PlayerOrder[i])
+}
+
+// Passes the action on after the current player, finishing streets and the hand as needed
+func (g *HoldemGame) advance() {
+	remaining := g.Remaining()
+	if len(remaining) == 1 {
+		g.finishHand(remaining)
+		return
+	}
+
+	if next := g.nextSeat(g.CurrentPlayer, g.needsAction); next != -1 {
+		g.CurrentPlayer = next
+		return
+	}
+
+	// Everyone has matched the bet, so the street is over
+	g.EndRound()
+
+	if g.Phase == HoldemPhaseRiver {
+		g.finishHand(remaining)
+		return
+	}
+	g.dealStreet()
+
+	// With at most one player left who can bet, the rest of the board is dealt straight away
+	if g.CanBet() <= 1 {
+		for g.Phase != HoldemPhaseRiver {
+			g.dealStreet()
+		}
+
+		g.finishHand(remaining)
+		return
+	}
+
+	g.CurrentPlayer = g.nextSeat(g.Dealer, g.needsAction)
+}
+
+// Moves on to the next street and deals its cards to the board
+func (g *HoldemGame) dealStreet() {
+	for i, street := range holdemStreets {
+		if street.phase == g.Phase {
+			g.Phase = holdemStreets[i+1].phase
+			g.Board = append(g.Board, g.draw(holdemStreets[i+1].cards)...)
+			return
+		}
+	}
+}
+
+// Awards the pots to the best hands among the remaining players and schedules the next hand
+func (g *HoldemGame) finishHand(remaining []string) {
+	g.CurrentPlayer = -1
+	g.Phase = HoldemPhaseShowdown
+
+	hands := make(map[string]PokerHand)
+	if len(remaining) > 1 {
+		for _, id := range remaining {
+			hands[id] = EvaluatePoker(append(append(Cards{}, g.holes[id]...), g.Board...))
+			g.Shown[id] = g.holes[id]
+		}
+	}
+
+	won := g.Award(func(pot Pot) []string {
+		winners := []string{}
+		for _, id := range pot.Players {
+			if len(winners) == 0 || hands[id] > hands[winners[0]] {
+				winners = []string{id}
+			} else if hands[id] == hands[winners[0]] {
+				winners = append(winners, id)
+			}
+		}
+
+		// Odd chips go to the winners closest to the dealer's left
+		sort.Slice(winners, func(i, j int) bool {
+			return g.fromDealer(winners[i]) < g.fromDealer(winners[j])
+		})
+		return winners
+	})
+
+	for _, id := range g.PlayerOrder {
+		if won[id] == 0 && !g.InHand(id) {
+			continue
+		}
+
+		result := HoldemResult{Player: id, Won: won[id]}
+		if h, ok := hands[id]; ok {
+			result.Hand = h.String()
+		}
+		g.Results = append(g.Results, result)
+	}
+
+	g.lobby.after(holdemShowdownDelay, g.nextHand)
+}
+
+// How many seats to the left of the dealer the player sits
+func (g *HoldemGame) fromDealer(player string) int {
+	n := len(g.PlayerOrder)
+	for i := 1; i <= n; i++ {
+		if g.PlayerOrder[(g.Dealer+i)%n] == player {
+			return i
+		}
+	}
+
+	return n
+}
+
+// Deals the next hand if the game is still being played. Runs on the lobby's goroutine
+func (g *HoldemGame) nextHand() {
+	if g.lobby.game != g || g.Phase != HoldemPhaseShowdown {
+		return
+	}
+
+	// Wait for disconnected players to come back or an admin to resume the game
+	if g.lobby.onHold() {
+		g.lobby.after(holdemShowdownDelay, g.nextHand)
+		return
+	}
+
+	g.startHand()
+	g.lobby.Sync()
+}
+
+func (g *HoldemGame) endGame() {
+	g.Phase = HoldemPhaseDone
+	g.CurrentPlayer = -1
+	g.Winners = []string{}
+	for _, id := range g.PlayerOrder {
+		if g.Stacks[id] > 0 {
+			g.Winners = append(g.Winners, id)
+		}
+	}
+
+	// Whoever lasted longest places highest
+	for i := len(g.Eliminated) - 1; i >= 0; i-- {
+		g.Winners = append(g.Winners, g.Eliminated[i])
+	}
+}
+
+func (g *HoldemGame) act(player string, move string, data interface{}) error {
+	if g.CurrentPlayer == -1 || g.PlayerOrder[g.CurrentPlayer] != player {
+		return errors.New("it is not your turn")
+	}
+
+	if err := g.Act(player, move, data); err != nil {
+		return err
+	}
+
+	g.advance()
+	return nil
+}
+
+// Name implements FreezableGame
+func (*HoldemGame) Name(client *Client) string {
+	return "holdem"
+}
+
+// State implements FreezableGame
+func (g *HoldemGame) State(client *Client) interface{} {
+	return &HoldemState{
+		HoldemGame: *g,
+		Hole:       g.holes[g.lobby.Client(client).ID],
+		Lobby:      g.lobby.State(client),
+	}
+}
+
+// LegalMoves implements FreezableGame. The data says how much a call costs and what the
+// player may raise to
+func (g *HoldemGame) LegalMoves(client *Client) ([]string, map[string]interface{}) {
+	if g.Phase == HoldemPhaseDone {
+		return []string{MoveReturn}, nil
+	}
+
+	id := g.lobby.Client(client).ID
+	if g.CurrentPlayer == -1 || g.PlayerOrder[g.CurrentPlayer] != id {
+		return nil, nil
+	}
+
+	return g.Moves(id)
+}
+
+// ExecuteMoves implements FreezableGame
+func (g *HoldemGame) ExecuteMoves(client *Client, moves []string, data interface{}) error {
+	return g.act(g.lobby.Client(client).ID, moves[0], data)
+}
+
+// Inspect implements InspectableGame
+func (g *HoldemGame) Inspect() interface{} {
+	return struct {
+		*HoldemGame
+		Holes map[string]Cards `json:"holes"`
+		Deck  Cards            `json:"deck"`
+		Acted map[string]bool  `json:"acted"`
+	}{g, g.holes, g.deck.Cards(), g.Betting.acted}
+}
+
+// How good the bot thinks its cards are, from 0 to 1
+func (g *HoldemGame) strength(player string) float64 {
+	hole := g.holes[player]
+	if len(g.Board) == 0 {
+		high, low := hole[0].Rank(), hole[1].Rank()
+		if low > high {
+			high, low = low, high
+		}
+
+		s := float64(high+low-4) / 24 * 0.6
+		if high == low {
+			s += 0.35
+		}
+		if hole[0].Suit() == hole[1].Suit() {
+			s += 0.05
+		}
+
+		return math.Min(s, 1)
+	}
+
+	hand := EvaluatePoker(append(append(Cards{}, hole...), g.Board...))
+	board := EvaluatePoker(g.Board)
+	s := float64(hand.Category()) / float64(StraightFlush)
+	// Hands that only use the board are worth much less
+	if hand.Category() == board.Category() {
+		s /= 2
+	}
+
+	return math.Min(s*1.6, 1)
+}
+
+func (g *HoldemGame) SelectMoves(client *Client, moves []string) []string {
+	id := g.lobby.Client(client).ID
+	s := g.strength(id) + rand.Float64()*0.2 - 0.1
+	toCall := g.CurrentBet - g.Bets[id]
+
+	switch {
+	case s > 0.6 && slices.Contains(moves, moveRaise):
+		return []string{moveRaise}
+	case toCall == 0:
+		return []string{moveCheck}
+	case s > 0.3 || toCall <= g.BigBlind && s > 0.15:
+		return []string{moveCall}
+	}
+
+	return []string{moveFold}
+}
+
+// SelectData implements SmartDataGame by raising more with better cards
+func (g *HoldemGame) SelectData(client *Client, moves []string) interface{} {
+	if moves[0] != moveRaise {
+		return nil
+	}
+
+	id := g.lobby.Client(client).ID
+	min, max := g.RaiseRange(id)
+	pot := 0
+	for _, p := range g.SidePots() {
+		pot += p.Amount
+	}
+
+	to := min + int(float64(pot)*g.strength(id)/2)
+	if to > max || g.strength(id) > 0.9 {
+		to = max
+	}
+
+	return map[string]interface{}{"amount": float64(to)}
+}
+
+func init() {
+	registerGame(&GameData{
+		Create:     NewHoldem,
+		Name:       "holdem",
+		MinPlayers: 2,
+		MaxPlayers: 4,
+	})
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Two teams of partners sitting across from each other bid how many tricks they will take,
+// with spades always trump. A team that makes its bid scores 10 points per trick bid and 1 per
+// extra trick (a bag); every 10 bags cost 100 points. A failed bid loses 10 points per trick.
+// Bidding nil (0) promises to take no tricks for 100 points, or 200 for blind nil, which is
+// bid before looking at the cards by a player whose team is behind by 100 or more. The first
+// team to 500 wins
+
+const (
+	moveBlindNil = "blind_nil"
+	moveLook     = "look"
+)
+
+const (
+	spadesBlindNil    = -1 // Bid for blind nil
+	spadesNilScore    = 100
+	spadesBagsPenalty = 10 // Every this many bags costs 100 points
+	spadesBlindBehind = 100
+	spadesGameScore   = 500
+)
+
+// Before TrickPhasePlay in every round
+const SpadesPhaseBid TrickPhase = "bid"
+
+type SpadesGame struct {
+	*TrickGame
+	Teams        [2][]string    `json:"teams"` // Partners, who sit across from each other
+	Bids         map[string]int `json:"bids"`  // 0 for nil and -1 for blind nil
+	Bags         [2]int         `json:"bags"`
+	SpadesBroken bool           `json:"spades_broken"` // Whether spades may be led
+
+	looked map[string]bool // Who has seen their cards this round
+}
+
+type SpadesState struct {
+	TrickState
+	Teams        [2][]string    `json:"teams"`
+	Bids         map[string]int `json:"bids"`
+	Bags         [2]int         `json:"bags"`
+	SpadesBroken bool           `json:"spades_broken"`
+}
+
+func NewSpades(l *Lobby) FreezableGame {
+	g := &SpadesGame{}
+	g.TrickGame = NewTrickGame(l, g, false)
+
+	for i, id := range g.PlayerOrder {
+		g.Teams[i%2] = append(g.Teams[i%2], id)
+	}
+
+	g.StartRound()
+	return g
+}
+
+// The index of the player's team in Teams
+func (g *SpadesGame) team(player string) int {
+	return g.seat(player) % 2
+}
+
+// The player's team's score, which both partners share
+func (g *SpadesGame) teamScore(team int) int {
+	return g.Scores[g.Teams[team][0]]
+}
+
+// Deck implements TrickRules
+func (*SpadesGame) Deck() *Pile {
+	return NewDeck(1, false)
+}
+
+// Trump implements TrickRules
+func (*SpadesGame) Trump() Suit {
+	return Spades
+}
+
+// FirstLeader implements TrickRules
+func (g *SpadesGame) FirstLeader() string {
+	return g.Eldest()
+}
+
+// RoundStarted implements TrickRoundStarter
+func (g *SpadesGame) RoundStarted() {
+	g.Phase = SpadesPhaseBid
+	g.Bids = make(map[string]int)
+	g.SpadesBroken = false
+
+	// Players who may bid blind nil don't see their cards until they choose not to
+	g.looked = make(map[string]bool)
+	for _, id := range g.PlayerOrder {
+		g.looked[id] = !g.mayBidBlind(id)
+	}
+}
+
+// Whether the player's team is far enough behind to bid blind nil
+func (g *SpadesGame) mayBidBlind(player string) bool {
+	team := g.team(player)
+	return g.teamScore(1-team)-g.teamScore(team) >= spadesBlindBehind
+}
+
+// Allowed implements TrickRules
+func (g *SpadesGame) Allowed(player string, c Card) bool {
+	if g.Leading() && c.Suit() == Spades {
+		return g.SpadesBroken
+	}
+
+	return true
+}
+
+// TrickDone implements TrickRules
+func (g *SpadesGame) TrickDone(trick *Trick) {
+	for _, p := range trick.Plays {
+		if p.Card.Suit() == Spades {
+			g.SpadesBroken = true
+		}
+	}
+}
+
+// ScoreRound implements TrickRules
+func (g *SpadesGame) ScoreRound() map[string]int {
+	points := make(map[string]int)
+
+	for team, players := range g.Teams {
+		bid, won, score := 0, 0, 0
+		for _, id := range players {
+			switch g.Bids[id] {
+			case 0, spadesBlindNil:
+				nilScore := spadesNilScore
+				if g.Bids[id] == spadesBlindNil {
+					nilScore *= 2
+				}
+
+				if g.TricksWon[id] == 0 {
+					score += nilScore
+				} else {
+					score -= nilScore
+					// Tricks taken by a failed nil don't help the partner's bid, but still count as bags
+					g.Bags[team] += g.TricksWon[id]
+					score += g.TricksWon[id]
+				}
+			default:
+				bid += g.Bids[id]
+				won += g.TricksWon[id]
+			}
+		}
+
+		if won >= bid {
+			score += 10*bid + won - bid
+			g.Bags[team] += won - bid
+		} else {
+			score -= 10 * bid
+		}
+
+		for g.Bags[team] >= spadesBagsPenalty {
+			g.Bags[team] -= spadesBagsPenalty
+			score -= 10 * spadesBagsPenalty
+		}
+
+		for _, id := range players {
+			points[id] = score
+		}
+	}
+
+	return points
+}
+
+// GameOver implements TrickRules
+func (g *SpadesGame) GameOver() bool {
+	a, b := g.teamScore(0), g.teamScore(1)
+	return (a >= spadesGameScore || b >= spadesGameScore) && a != b
+}
+
+func (g *SpadesGame) bid(player string, bid int) error {
+	if g.Phase != SpadesPhaseBid || g.Current() != player {
+		return errors.New("it is not your turn to bid")
+	}
+
+	if bid < spadesBlindNil || bid > len(g.Hand(player)) {
+		return errors.New("invalid bid")
+	}
+
+	if bid == spadesBlindNil && (g.looked[player] || !g.mayBidBlind(player)) {
+		return errors.New("you cannot bid blind nil")
+	}
+
+	if bid != spadesBlindNil && !g.looked[player] {
+		return errors.New("you must look at your cards first")
+	}
+
+	g.Bids[player] = bid
+	g.looked[player] = true
+	g.CurrentPlayer = (g.CurrentPlayer + 1) % len(g.PlayerOrder)
+
+	if len(g.Bids) == len(g.PlayerOrder) {
+		g.Phase = TrickPhasePlay
+		g.CurrentPlayer = g.seat(g.FirstLeader())
+	}
+
+	return nil
+}
+
+// Name implements FreezableGame
+func (*SpadesGame) Name(client *Client) string {
+	return "spades"
+}
+
+// State implements FreezableGame
+func (g *SpadesGame) State(client *Client) interface{} {
+	ts := g.TrickState(client)
+	if !g.looked[g.lobby.Client(client).ID] {
+		ts.Hand = Cards{}
+	}
+
+	return &SpadesState{
+		TrickState:   ts,
+		Teams:        g.Teams,
+		Bids:         g.Bids,
+		Bags:         g.Bags,
+		SpadesBroken: g.SpadesBroken,
+	}
+}
+
+// LegalMoves implements FreezableGame
+func (g *SpadesGame) LegalMoves(client *Client) (moves []string, _ map[string]interface{}) {
+	if g.Phase != SpadesPhaseBid {
+		return g.TrickGame.LegalMoves(client)
+	}
+
+	id := g.lobby.Client(client).ID
+	if g.Current() != id {
+		return
+	}
+
+	if !g.looked[id] {
+		return []string{moveBlindNil, moveLook}, nil
+	}
+
+	for i := 0; i <= len(g.Hand(id)); i++ {
+		moves = append(moves, strconv.Itoa(i))
+	}
+
+	return
+}
+
+// ExecuteMoves implements FreezableGame
+func (g *SpadesGame) ExecuteMoves(client *Client, moves []string, data interface{}) error {
+	if g.Phase != SpadesPhaseBid {
+		return g.TrickGame.ExecuteMoves(client, moves, data)
+	}
+
+	id := g.lobby.Client(client).ID
+	switch moves[0] {
+	case moveLook:
+		g.looked[id] = true
+		return nil
+	case moveBlindNil:
+		return g.bid(id, spadesBlindNil)
+	}
+
+	bid, err := strconv.Atoi(moves[0])
+	if err != nil {
+		return err
+	}
+
+	return g.bid(id, bid)
+}
+
+// Inspect implements InspectableGame
+func (g *SpadesGame) Inspect() interface{} {
+	return struct {
+		*SpadesGame
+		Hands  map[string]Cards `json:"hands"`
+		Looked map[string]bool  `json:"looked"`
+	}{g, g.hands.Cards(), g.looked}
+}
+
+func (g *SpadesGame) SelectMoves(client *Client, moves []string) []string {
+	id := g.lobby.Client(client).ID
+
+	switch {
+	case moves[0] == moveBlindNil:
+		return []string{moveLook}
+	case g.Phase == SpadesPhaseBid:
+		return []string{strconv.Itoa(g.botBid(id))}
+	}
+
+	return []string{g.botCard(id).String()}
+}
+
+// Roughly counts the tricks the hand should take
+func (g *SpadesGame) botBid(player string) int {
+	hand := g.Hand(player)
+	tricks := 0.0
+
+	for _, suit := range Suits {
+		cards := hand.OfSuit(suit)
+		cards.SortByRank()
+
+		if suit == Spades {
+			for _, c := range cards {
+				if c.Rank() >= Queen {
+					tricks++
+				}
+			}
+			if len(cards) > 3 {
+				tricks += float64(len(cards) - 3)
+			}
+			continue
+		}
+
+		if len(cards) > 0 && cards[0].Rank() == Ace {
+			tricks++
+		}
+		if len(cards) > 1 && cards.Contains(NewCard(King, suit)) {
+			tricks += 0.7
+		}
+	}
+
+	// Nil is worth trying with nothing high at all
+	if tricks < 0.5 && len(hand.OfSuit(Spades)) < 3 {
+		return 0
+	}
+
+	if tricks < 1 {
+		return 1
+	}
+
+	return int(tricks + 0.5)
+}
+
+// Whether the player's team has already taken the tricks it bid, not counting nil bids
+func (g *SpadesGame) teamMadeBid(player string) bool {
+	bid, won := 0, 0
+	for _, id := range g.Teams[g.team(player)] {
+		if g.Bids[id] > 0 {
+			bid += g.Bids[id]
+			won += g.TricksWon[id]
+		}
+	}
+
+	return won >= bid
+}
+
+// Plays to win tricks until the team's bid is made, then ducks to avoid bags
+func (g *SpadesGame) botCard(player string) Card {
+	playable := g.Playable(player)
+	playable.SortByRank()
+	lowest := playable[len(playable)-1]
+
+	if g.Bids[player] <= 0 || g.teamMadeBid(player) {
+		return g.LowestLoser(player)
+	}
+
+	if g.Leading() {
+		// Cash an ace if there is one, otherwise lead low
+		for _, c := range playable {
+			if c.Rank() == Ace && c.Suit() != Spades {
+				return c
+			}
+		}
+
+		return lowest
+	}
+
+	// Leave the trick to a partner who is already winning it
+	if g.team(g.winning().Player) == g.team(player) && len(g.Trick.Plays) == len(g.PlayerOrder)-1 {
+		return lowest
+	}
+
+	// Take the trick as cheaply as possible
+	for i := len(playable) - 1; i >= 0; i-- {
+		if g.wouldWin(playable[i]) {
+			return playable[i]
+		}
+	}
+
+	return lowest
+}
+
+func init() {
+	registerGame(&GameData{
+		Create:     NewSpades,
+		Name:       "spades",
+		MinPlayers: 4,
+		MaxPlayers: 4,
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import "testing"
+
+// Deals a game of spades to d and a to c. d and b are partners, as are a and c
+func newTestSpades(t *testing.T) *SpadesGame {
+	t.Helper()
+
+	l := newLobby("spades", "", nil, NewLocalPubSub())
+	for i, id := range []string{"d", "a", "b", "c"} {
+		l.Clients[id] = &LobbyClient{ID: id, JoinedAt: int64(i)}
+	}
+
+	return NewSpades(l).(*SpadesGame)
+}
+
+func TestSpadesScoreRound(t *testing.T) {
+	tests := []struct {
+		name   string
+		bids   map[string]int
+		won    map[string]int
+		bags   [2]int // Before the round
+		scores [2]int // Of d's team and a's team
+		after  [2]int // Bags after the round
+	}{
+		{
+			name:   "made and failed bids",
+			bids:   map[string]int{"d": 3, "b": 2, "a": 4, "c": 3},
+			won:    map[string]int{"d": 4, "b": 2, "a": 3, "c": 4},
+			scores: [2]int{51, 70},
+			after:  [2]int{1, 0},
+		},
+		{
+			name:   "set",
+			bids:   map[string]int{"d": 4, "b": 4, "a": 2, "c": 2},
+			won:    map[string]int{"d": 5, "b": 2, "a": 3, "c": 3},
+			scores: [2]int{-80, 42},
+			after:  [2]int{0, 2},
+		},
+		{
+			name:   "nil",
+			bids:   map[string]int{"d": 0, "b": 4, "a": 5, "c": 3},
+			won:    map[string]int{"d": 0, "b": 5, "a": 4, "c": 4},
+			scores: [2]int{141, 80},
+			after:  [2]int{1, 0},
+		},
+		{
+			name:   "failed nil",
+			bids:   map[string]int{"d": 0, "b": 3, "a": 5, "c": 3},
+			won:    map[string]int{"d": 2, "b": 3, "a": 4, "c": 4},
+			scores: [2]int{-68, 80},
+			after:  [2]int{2, 0},
+		},
+		{
+			name:   "failed nil doesn't help the partner",
+			bids:   map[string]int{"d": 0, "b": 5, "a": 4, "c": 2},
+			won:    map[string]int{"d": 3, "b": 4, "a": 4, "c": 2},
+			scores: [2]int{-147, 60},
+			after:  [2]int{3, 0},
+		},
+		{
+			name:   "blind nil",
+			bids:   map[string]int{"d": spadesBlindNil, "b": 4, "a": 5, "c": 3},
+			won:    map[string]int{"d": 0, "b": 4, "a": 5, "c": 4},
+			scores: [2]int{240, 81},
+			after:  [2]int{0, 1},
+		},
+		{
+			name:   "failed blind nil",
+			bids:   map[string]int{"d": spadesBlindNil, "b": 4, "a": 5, "c": 3},
+			won:    map[string]int{"d": 1, "b": 4, "a": 4, "c": 4},
+			scores: [2]int{-159, 80},
+			after:  [2]int{1, 0},
+		},
+		{
+			name:   "bag penalty",
+			bids:   map[string]int{"d": 2, "b": 2, "a": 3, "c": 3},
+			won:    map[string]int{"d": 4, "b": 3, "a": 3, "c": 3},
+			bags:   [2]int{7, 9},
+			scores: [2]int{-57, 60},
+			after:  [2]int{0, 9},
+		},
+		{
+			name:   "bags past the penalty carry over",
+			bids:   map[string]int{"d": 1, "b": 1, "a": 3, "c": 3},
+			won:    map[string]int{"d": 4, "b": 3, "a": 3, "c": 3},
+			bags:   [2]int{8, 0},
+			scores: [2]int{-75, 60},
+			after:  [2]int{3, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestSpades(t)
+			g.Bids = tt.bids
+			g.TricksWon = tt.won
+			g.Bags = tt.bags
+
+			points := g.ScoreRound()
+			for team, players := range g.Teams {
+				for _, id := range players {
+					if points[id] != tt.scores[team] {
+						t.Errorf("%s scored %d, want %d", id, points[id], tt.scores[team])
+					}
+				}
+			}
+
+			if g.Bags != tt.after {
+				t.Errorf("bags %v, want %v", g.Bags, tt.after)
+			}
+		})
+	}
+}
+
+func TestSpadesBlindNilBid(t *testing.T) {
+	g := newTestSpades(t)
+	if g.Current() != "a" {
+		t.Fatalf("%s bids first, want a", g.Current())
+	}
+
+	if err := g.bid("a", spadesBlindNil); err == nil {
+		t.Error("a bid blind nil without being behind")
+	}
+
+	// Once a and c are behind by 100 they may bid blind nil, but only before looking
+	g.Scores = map[string]int{"d": 150, "b": 150, "a": 50, "c": 50}
+	g.RoundStarted()
+	if g.looked["a"] || !g.looked["d"] {
+		t.Fatalf("looked %v, want only a and c not to have looked", g.looked)
+	}
+
+	if err := g.bid("a", 3); err == nil {
+		t.Error("a bid without looking at their cards")
+	}
+
+	if err := g.bid("a", spadesBlindNil); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Bids["a"] != spadesBlindNil || g.Current() != "b" {
+		t.Errorf("bids %v with %s next, want a to have bid blind nil and b next", g.Bids, g.Current())
+	}
+
+	g.CurrentPlayer = g.seat("c")
+	g.looked["c"] = true
+	if err := g.bid("c", spadesBlindNil); err == nil {
+		t.Error("c bid blind nil after looking")
+	}
+}
@@ -254,6 +254,15 @@ func (game *TheMind) ExecuteMoves(client *Client, moves []string, data interface
 	return nil
 }
 
+// Inspect implements InspectableGame
+func (game *TheMind) Inspect() interface{} {
+	return struct {
+		*TheMind
+		Hands    Hands `json:"hands"`
+		DrawPile *Pile `json:"draw_pile"`
+	}{game, game.hands, game.drawPile}
+}
+
 func init() {
 	registerGame(&GameData{
 		Create:     NewTheMind,
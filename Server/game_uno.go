@@ -1,13 +1,10 @@
 package main
 
 import (
-	"errors"
 	"math/rand"
 	"strconv"
 	"strings"
 	"time"
-
-	"golang.org/x/exp/slices"
 )
 
 // This game consists of colored cards 0, 1, 2, ..., 9, reverse, skip, and +2 (rgby)
@@ -73,30 +70,11 @@ func parseCard(raw int) (t cardType, col cardColor, num int) {
 }
 
 type Uno struct {
-	CurrentPlayer int       `json:"current_player"` // Whose turn is it
-	PlayPile      *Pile     `json:"play_pile"`      // What cards have been played so far
-	PlayerOrder   []string  `json:"player_order"`   // What order do players play in
-	Clockwise     bool      `json:"clockwise"`      // Are we moving clockwise
-	DrawNum       int       `json:"draw_num"`       // The number of cards that are to be drawn by whoever accepts it (default 0)
-	ChosenColor   cardColor `json:"chosen_color"`
-	Winners       []string  `json:"winners"` // Who won and in what order
-
-	lobby    *Lobby
-	unoAt    map[string]int64 // Whenever each player last reached one card
-	drawPile *Pile
-	hands    Hands
-}
-
-// Makes sure the draw pile has at least n cards. If draw pile could
-// not be refilled, (i.e. players have drawn too many cards), this
-// returns false
-func (u *Uno) checkDrawPile(n int) bool {
-	if len(*u.drawPile) < n {
-		u.drawPile.Insert(u.PlayPile.Draw(len(*u.PlayPile) - 1))
-		u.drawPile.Shuffle()
-	}
+	*Shedding
+	ChosenColor cardColor `json:"chosen_color"`
 
-	return len(*u.drawPile) >= n
+	lobby *Lobby
+	unoAt map[string]int64 // Whenever each player last reached one card
 }
 
 type UnoState struct {
@@ -112,33 +90,23 @@ func NewUno(l *Lobby) FreezableGame {
 	p := CreatePile(15*4+12*4, false)
 	p.Shuffle()
 
+	players := []string{}
+	for id := range l.Clients {
+		players = append(players, id)
+	}
+
 	g := &Uno{
+		Shedding:    NewShedding(players, p, 7),
 		lobby:       l,
-		Clockwise:   true,
 		ChosenColor: -1,
-		Winners:     []string{},
 		unoAt:       make(map[string]int64),
-		drawPile:    p,
-		hands:       Hands{},
-	}
-
-	for id := range l.Clients {
-		cards := Pile(p.Draw(7))
-		g.hands[id] = &cards
-		g.PlayerOrder = append(g.PlayerOrder, id)
 	}
 
 	// First card must be a color card
-	for {
-		cards := Pile(p.Draw(1))
-		_, col, _ := parseCard(cards[0])
-		if col != black {
-			g.PlayPile = &cards
-			break
-		}
-
-		p.Insert(cards)
-	}
+	g.StartPile(func(card int) bool {
+		_, col, _ := parseCard(card)
+		return col != black
+	})
 
 	return g
 }
@@ -156,7 +124,7 @@ func (g *Uno) isPlayable(next int) bool {
 	}
 
 	// Besides these conditions, we must compare with the last played card.
-	lType, lCol, lNum := parseCard((*g.PlayPile)[len(*g.PlayPile)-1])
+	lType, lCol, lNum := parseCard(g.Top())
 
 	// If the last card played changed the color, we must match the chosen color
 	if lCol == black {
@@ -177,44 +145,13 @@ func (g *Uno) isPlayable(next int) bool {
 	return lType == nType
 }
 
-func (g *Uno) nextPlayer() {
-	cur := g.CurrentPlayer
-	for {
-		if g.Clockwise {
-			g.CurrentPlayer = (g.CurrentPlayer + 1) % len(g.PlayerOrder)
-		} else {
-			g.CurrentPlayer -= 1
-			// Euclidean modulus
-			if g.CurrentPlayer < 0 {
-				g.CurrentPlayer += len(g.PlayerOrder)
-			}
-		}
-
-		if g.CurrentPlayer == cur || len(*g.hands[g.PlayerOrder[g.CurrentPlayer]]) > 0 {
-			break
-		}
-	}
-}
-
 func (g *Uno) ExecuteMoves(client *Client, moves []string, data interface{}) (err error) {
 	lc := g.lobby.Client(client)
 	hand := g.hands[lc.ID]
 
 	switch moves[0] {
 	case moveDraw:
-		num := 1
-		if g.DrawNum > 0 {
-			num = g.DrawNum
-			g.nextPlayer()
-			g.DrawNum = 0
-		}
-
-		enough := g.checkDrawPile(num)
-		hand.Insert(g.drawPile.Draw(num))
-
-		if !enough {
-			return errors.New("all cards have been drawn")
-		}
+		return g.Draw(lc.ID)
 
 	case moveUno:
 		for id, at := range g.unoAt {
@@ -224,14 +161,15 @@ func (g *Uno) ExecuteMoves(client *Client, moves []string, data interface{}) (er
 
 			if id == lc.ID {
 				g.unoAt[id] = 0
+				g.lobby.Announce("%s called uno", lc.Name)
 				continue
 			}
 
 			// If the grace period is over, force draw 2
 			if at+unoGracePeriod < time.Now().UnixMilli() {
-				g.checkDrawPile(2)
-				g.hands[id].Insert(g.drawPile.Draw(2))
+				g.Give(id, 2)
 				g.unoAt[id] = 0
+				g.lobby.Announce("%s called uno on %s", lc.Name, g.lobby.Clients[id].Name)
 			}
 		}
 
@@ -239,24 +177,16 @@ func (g *Uno) ExecuteMoves(client *Client, moves []string, data interface{}) (er
 		split := strings.Split(moves[0], "_")
 		// Get card id
 		raw, _ := strconv.Atoi(split[0])
-		// Add card to play pile
-		g.PlayPile.Insert([]int{raw})
-		// Remove card from player's hand
-		i := slices.Index(*hand, raw)
-		*hand = slices.Delete(*hand, i, i+1)
 		// Clear previously chosen color
 		g.ChosenColor = -1
 		// Take special action depending on type of card
 		ct, c, _ := parseCard(raw)
 		switch ct {
 		case reverse:
-			g.Clockwise = !g.Clockwise
 			// In a two player UNO game, the reverse card acts as a skip
-			if len(g.PlayerOrder)-len(g.Winners) == 2 {
-				g.nextPlayer()
-			}
+			g.Reverse()
 		case skip:
-			g.nextPlayer()
+			g.NextPlayer()
 		case drawTwo:
 			g.DrawNum += 2
 		case drawFour:
@@ -267,6 +197,8 @@ func (g *Uno) ExecuteMoves(client *Client, moves []string, data interface{}) (er
 			col, _ := strconv.Atoi(split[1])
 			g.ChosenColor = cardColor(col)
 		}
+		// Move card from player's hand to play pile
+		g.Discard(lc.ID, raw)
 		// Check if player has one card remaining
 		if len(*hand) == 1 {
 			g.unoAt[lc.ID] = time.Now().UnixMilli()
@@ -274,11 +206,10 @@ func (g *Uno) ExecuteMoves(client *Client, moves []string, data interface{}) (er
 		}
 		// Check if player has won
 		if len(*hand) == 0 {
-			g.Winners = append(g.Winners, lc.ID)
 			g.unoAt[lc.ID] = 0
 		}
 		// Move onto next player
-		g.nextPlayer()
+		g.NextPlayer()
 	}
 
 	return
@@ -303,7 +234,7 @@ func (g *Uno) LegalMoves(client *Client) (moves []string, extra map[string]inter
 			moves = append(moves, moveUno)
 		}
 	}
-	if g.PlayerOrder[g.CurrentPlayer] != c.ID {
+	if g.Current() != c.ID {
 		return
 	}
 
@@ -342,6 +273,16 @@ func (g *Uno) State(client *Client) interface{} {
 	}
 }
 
+// Inspect implements InspectableGame
+func (g *Uno) Inspect() interface{} {
+	return struct {
+		*Uno
+		Hands    Hands            `json:"hands"`
+		DrawPile *Pile            `json:"draw_pile"`
+		UnoAt    map[string]int64 `json:"uno_at"`
+	}{g, g.hands, g.drawPile, g.unoAt}
+}
+
 func (g *Uno) SelectMoves(client *Client, moves []string) []string {
 	for _, m := range moves {
 		if m != moveDraw && m != moveUno {
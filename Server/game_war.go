@@ -175,6 +175,16 @@ func (game *War) State(client *Client) interface{} {
 	return ws
 }
 
+// Inspect implements InspectableGame
+func (game *War) Inspect() interface{} {
+	return struct {
+		*War
+		Phase  WarPhase       `json:"phase"`
+		Placed map[string]int `json:"placed"`
+		Hands  Hands          `json:"hands"`
+	}{game, game.phase, game.placed, game.hands}
+}
+
 func (*War) SelectMoves(client *Client, moves []string) []string {
 	for _, m := range moves {
 		if m != moveWar && m != moveNextRound {
@@ -1,6 +1,6 @@
 module server
 
-go 1.18
+go 1.21
 
 require github.com/gorilla/websocket v1.5.0
 
@@ -10,6 +10,21 @@ require golang.org/x/exp v0.0.0-20230203172020-98cc5a0785f9
 
 require github.com/golang-jwt/jwt/v5 v5.2.0
 
+require gopkg.in/yaml.v3 v3.0.1
+
+require github.com/prometheus/client_golang v1.19.1
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
+
 // require (
 // 	github.com/petermattis/goid v0.0.0-20180202154549-b0b1615b78e5 // indirect
 // 	github.com/sasha-s/go-deadlock v0.3.1 // indirect
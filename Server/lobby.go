@@ -3,13 +3,14 @@ package main
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"math/rand"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -24,17 +25,44 @@ const (
 	MoveTransfer   = "lobby.transfer"
 	MoveReturn     = "lobby.return"
 	MoveAddBot     = "lobby.add_bot"
+	MoveRefreshKey = "lobby.refresh_key"
+	MoveVoiceMute  = "lobby.voice_mute"
 )
 
+// Sent when a client tries to join a lobby owned by another node. They must reconnect
+// with the lobby in the query so the socket is routed there
+var errLobbyElsewhere = errors.New("this lobby is hosted on another server")
+
+// Everything in a lobby, including the game and the clients' send state, is only touched
+// from the goroutine of its mailbox. Other goroutines go through call and post
 type Lobby struct {
 	Clients map[string]*LobbyClient `json:"clients"`
 	Game    *string                 `json:"game"`
 	ID      string                  `json:"id"`
-	Frozen  bool                    `json:"frozen"`
+	Frozen  bool                    `json:"frozen"` // Waiting for disconnected players
+	Paused  bool                    `json:"paused"` // Held by an admin until they resume it
 	ChatKey string                  `json:"chat_key"`
 	game    FreezableGame
+	ip      string     // Of the client who created the lobby
+	rules   *GameRules // Handed to the games the lobby starts
+	chat    *ChatLobby
+	voice   *VoiceLobby
+
+	mailbox *mailbox
+	metrics lobbyMetrics
+	members sync.Map // IDs of the clients, so chat and voice can check membership without a call
+}
 
-	mu sync.RWMutex
+func newLobby(id string, ip string, rules *GameRules, bus PubSub) *Lobby {
+	return &Lobby{
+		ID:      id,
+		Clients: make(map[string]*LobbyClient),
+		ip:      ip,
+		rules:   rules,
+		chat:    newChatLobby(id, bus),
+		voice:   newVoiceLobby(id, bus),
+		mailbox: newMailbox(),
+	}
 }
 
 type LobbyState struct {
@@ -58,19 +86,59 @@ func (l *Lobby) Client(c *Client) *LobbyClient {
 	return nil
 }
 
+// Runs f on the lobby's goroutine and waits for it. Returns errLobbyGone if the lobby was
+// deleted first. Must not be called from the lobby's goroutine
+func (l *Lobby) call(f func() error) error {
+	return l.mailbox.call(func() error {
+		defer l.reportMetrics()
+		return f()
+	})
+}
+
+// Runs f on the lobby's goroutine without waiting for it
+func (l *Lobby) post(f func()) {
+	l.mailbox.post(func() {
+		defer l.reportMetrics()
+		f()
+	})
+}
+
+// Runs f on the lobby's goroutine once d has passed, unless the lobby is deleted before then
+func (l *Lobby) after(d time.Duration, f func()) {
+	l.mailbox.after(d, func() {
+		defer l.reportMetrics()
+		f()
+	})
+}
+
 func (l *Lobby) Sync() {
 	for _, c := range l.Clients {
 		c.Client.Sync()
 	}
 }
 
+// Sends a system message to the lobby's chat
+func (l *Lobby) Announce(format string, a ...interface{}) {
+	l.logger().Info("announce", "message", fmt.Sprintf(format, a...))
+	l.chat.sendSystemMessage(fmt.Sprintf(format, a...))
+}
+
+// The name of the game being played, lobby if none. Must run on the lobby's goroutine
+func (l *Lobby) gameName() string {
+	if l.game != nil && l.Game != nil {
+		return *l.Game
+	}
+
+	return "lobby"
+}
+
+// Whether the game waits, either for disconnected players or for an admin
+func (l *Lobby) onHold() bool {
+	return l.Frozen || l.Paused
+}
+
 func (l *Lobby) SyncAfter(t time.Duration) {
-	go func() {
-		time.Sleep(t)
-		l.mu.Lock()
-		l.Sync()
-		l.mu.Unlock()
-	}()
+	l.after(t, l.Sync)
 }
 
 func (l *Lobby) State(client *Client) *LobbyState {
@@ -84,79 +152,234 @@ func (l *Lobby) State(client *Client) *LobbyState {
 	}
 }
 
-func (lm *LobbyManager) RunBotRoutine(c *Client) {
-	go func() {
-		for {
-			time.Sleep(time.Millisecond * 1500)
+// Has the bot take a turn every botInterval until it leaves the lobby. Must run on the lobby's goroutine
+func (lm *LobbyManager) RunBotRoutine(lobby *Lobby, bot *LobbyClient) {
+	var turn func()
+	turn = func() {
+		// If we are no longer in the lobby, end the routine
+		if lobby.Clients[bot.ID] != bot {
+			return
+		}
 
-			l := lm.Lobby(c)
-			// If we are no longer in the lobby, end the routine
-			if l == nil {
-				break
-			}
+		lm.botTurn(lobby, bot)
+		lobby.after(lm.botInterval, turn)
+	}
 
-			l.mu.RLock()
-			lc := l.Client(c)
+	lobby.after(lm.botInterval, turn)
+}
 
-			// If there is no game do nothing
-			if l.game == nil || len(lc.legalMoves) == 0 {
-				l.mu.RUnlock()
-				continue
-			}
+// Picks and executes a move for the bot, if it has any. Must run on the lobby's goroutine
+func (lm *LobbyManager) botTurn(lobby *Lobby, bot *LobbyClient) {
+	// Bots stop moving once the server starts shutting down
+	if !lm.moves.TryRLock() {
+		return
+	}
+	defer lm.moves.RUnlock()
 
-			legalMoves := []string{}
-			for _, m := range lc.legalMoves {
-				if !strings.HasPrefix(m, "lobby.") {
-					legalMoves = append(legalMoves, m)
-				}
-			}
+	// If there is no game do nothing
+	if lobby.game == nil || len(bot.legalMoves) == 0 {
+		return
+	}
 
-			if len(legalMoves) == 0 {
-				l.mu.RUnlock()
-				continue
-			}
+	legalMoves := []string{}
+	for _, m := range bot.legalMoves {
+		if !strings.HasPrefix(m, "lobby.") {
+			legalMoves = append(legalMoves, m)
+		}
+	}
 
-			var chosenMoves []string
-			if smart, ok := l.game.(SmartGame); ok {
-				chosenMoves = smart.SelectMoves(lc.Client, legalMoves)
-			} else {
-				// Not-so-smart random legal move algorithm
-				chosenMoves = []string{legalMoves[rand.Intn(len(legalMoves))]}
-			}
+	if len(legalMoves) == 0 {
+		return
+	}
 
-			if len(chosenMoves) == 0 {
-				l.mu.RUnlock()
-				continue
-			}
+	var chosenMoves []string
+	if smart, ok := lobby.game.(SmartGame); ok {
+		chosenMoves = smart.SelectMoves(bot.Client, legalMoves)
+	} else {
+		// Not-so-smart random legal move algorithm
+		chosenMoves = []string{legalMoves[rand.Intn(len(legalMoves))]}
+	}
 
-			l.mu.RUnlock()
-			lm.ExecuteMoves(lc.Client, chosenMoves, nil)
-		}
-	}()
+	if len(chosenMoves) == 0 {
+		return
+	}
+
+	var data interface{}
+	if smart, ok := lobby.game.(SmartDataGame); ok {
+		data = smart.SelectData(bot.Client, chosenMoves)
+	}
+
+	lm.execute(lobby, bot.Client, chosenMoves, data)
 }
 
 type LobbyManager struct {
 	Lobbies       sync.Map
 	clientToLobby sync.Map
+	botInterval   time.Duration
+	maxLobbies    int            // 0 for no limit
+	maxPlayers    int            // 0 for no limit
+	maxBots       int            // 0 for no limit
+	lobbyLimiter  *IPLimiter     // Counts the lobbies created by each IP
+	owners        LobbyOwnership // Decides which lobbies this node may host
+	rules         *GameRules     // From the config, passed on to every lobby
+	bus           PubSub         // Carries the chat and voice of every lobby between nodes
+	draining      int32          // Set to 1 once the server starts shutting down
+	moves         sync.RWMutex   // Read locked while moves execute so shutdown can wait for them and stop bots
+}
+
+func NewLobbyManager(config *Config, owners LobbyOwnership, bus PubSub) (*LobbyManager, error) {
+	rules, err := NewGameRules(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LobbyManager{
+		botInterval:  config.BotInterval,
+		maxLobbies:   config.MaxLobbies,
+		maxPlayers:   config.MaxPlayers,
+		maxBots:      config.MaxBots,
+		lobbyLimiter: NewIPLimiter(config.MaxLobbiesPerIP),
+		owners:       owners,
+		rules:        rules,
+		bus:          bus,
+	}, nil
+}
+
+func (lm *LobbyManager) lobbyCount() int {
+	n := 0
+	lm.Lobbies.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+
+	return n
+}
+
+// Returns the lobby with the ID, creating it if it doesn't exist
+func (lm *LobbyManager) loadOrCreateLobby(lobbyID string, ip string) (*Lobby, error) {
+	if entry, ok := lm.Lobbies.Load(lobbyID); ok {
+		return entry.(*Lobby), nil
+	}
+
+	if lm.maxLobbies > 0 && lm.lobbyCount() >= lm.maxLobbies {
+		return nil, errors.New("the server is full, try again later")
+	}
+
+	if !lm.lobbyLimiter.Acquire(ip) {
+		return nil, errors.New("you have created too many lobbies")
+	}
+
+	lobby := newLobby(lobbyID, ip, lm.rules, lm.bus)
+	// Someone else may have created it in the meantime
+	if entry, loaded := lm.Lobbies.LoadOrStore(lobbyID, lobby); loaded {
+		lm.lobbyLimiter.Release(ip)
+		return entry.(*Lobby), nil
+	}
+
+	lobby.chat.subscribe()
+	lobby.voice.subscribe()
+	go lobby.mailbox.run()
+	return lobby, nil
+}
+
+// Forgets the lobby along with its chat and voice and stops its goroutine. Must run on the lobby's goroutine
+func (lm *LobbyManager) deleteLobby(lobby *Lobby) {
+	// The lobby may have been deleted already
+	if lm.Lobbies.CompareAndDelete(lobby.ID, lobby) {
+		for _, c := range lobby.Clients {
+			lm.clientToLobby.Delete(c.Client)
+		}
+
+		lm.lobbyLimiter.Release(lobby.ip)
+		lobby.chat.close()
+		lobby.voice.close()
+		lobby.forgetMetrics()
+		lobby.metrics.deleted = true
+		lobby.mailbox.stop()
+	}
+}
+
+// The most players the selected game allows, or the most any game allows if none is selected.
+// Must run on the lobby's goroutine
+func (l *Lobby) maxGamePlayers() int {
+	if l.Game != nil {
+		return GAMES[*l.Game].MaxPlayers
+	}
+
+	max := 0
+	for _, g := range GAMES {
+		if g.MaxPlayers > max {
+			max = g.MaxPlayers
+		}
+	}
+
+	return max
+}
+
+func (l *Lobby) botCount() int {
+	n := 0
+	for _, c := range l.Clients {
+		if c.bot {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Whether another client may be added to the lobby. Must run on the lobby's goroutine
+func (lm *LobbyManager) full(lobby *Lobby) bool {
+	return lm.maxPlayers > 0 && len(lobby.Clients) >= lm.maxPlayers
 }
 
 type LobbyClient struct {
 	*Client      `json:"-"`
-	Name         string `json:"name"`
-	Leader       bool   `json:"leader"`
-	ID           string `json:"id"`
-	JoinedAt     int64  `json:"joined_at"`
-	Disconnected bool   `json:"disconnected"`
-	Bot          bool   `json:"bot"`
+	Name         string      `json:"name"`
+	Leader       bool        `json:"leader"`
+	ID           string      `json:"id"`
+	JoinedAt     int64       `json:"joined_at"`
+	Disconnected bool        `json:"disconnected"`
+	Bot          bool        `json:"bot"`
+	Voice        VoiceStatus `json:"voice"`
 	chatKey      string
 }
 
-// Removes client from given lobby. Does not sync
+// Removes the client from the lobby and tells everyone. Must run on the lobby's goroutine
+func (lm *LobbyManager) kick(lobby *Lobby, target *LobbyClient) {
+	lobby.Announce("%s was kicked", target.Name)
+	lm.remove(lobby, target.Client)
+	lobby.Sync()
+
+	target.SendError(errors.New("you have been kicked"))
+	target.Sync()
+}
+
+// Returns everyone to the lobby, dropping clients who are disconnected. Must run on the lobby's goroutine
+func (lm *LobbyManager) endGame(lobby *Lobby) {
+	lobby.game = nil
+	lobby.Frozen = false
+	lobby.Announce("The game has ended")
+
+	for id, c := range lobby.Clients {
+		if c.Disconnected {
+			delete(lobby.Clients, id)
+			lobby.members.Delete(id)
+			lm.clientToLobby.Delete(c.Client)
+			revokeKeys(lobby, id)
+		}
+	}
+
+	lobby.Sync()
+}
+
+// Removes client from given lobby. Does not sync. Must run on the lobby's goroutine
 func (lm *LobbyManager) remove(lobby *Lobby, client *Client) {
 	// Remove client from lobby
 	if c := lobby.Client(client); c != nil {
 		delete(lobby.Clients, c.ID)
+		lobby.members.Delete(c.ID)
 		lm.clientToLobby.Delete(client)
+		revokeKeys(lobby, c.ID)
 
 		if c.Leader {
 			var oldest *LobbyClient
@@ -186,7 +409,7 @@ func (lm *LobbyManager) remove(lobby *Lobby, client *Client) {
 	}
 
 	if !someone {
-		lm.Lobbies.Delete(lobby.ID)
+		lm.deleteLobby(lobby)
 	}
 }
 
@@ -200,6 +423,7 @@ func (lm *LobbyManager) Lobby(client *Client) *Lobby {
 	return nil
 }
 
+// Must run on the goroutine of the client's lobby, if they are in one
 func (lm *LobbyManager) Name(client *Client) string {
 	lobby := lm.Lobby(client)
 	if lobby != nil {
@@ -211,6 +435,7 @@ func (lm *LobbyManager) Name(client *Client) string {
 	return "lobby"
 }
 
+// Must run on the goroutine of the client's lobby, if they are in one
 func (lm *LobbyManager) LegalMoves(client *Client) (moves []string, extra map[string]interface{}) {
 	lobby := lm.Lobby(client)
 
@@ -219,21 +444,21 @@ func (lm *LobbyManager) LegalMoves(client *Client) (moves []string, extra map[st
 		return []string{MoveJoin, MoveReconnect}, nil
 	}
 
-	if lobby.Frozen {
+	if lobby.onHold() {
 		if lobby.Client(client).Leader {
 			moves = append(moves, MoveReturn)
 		}
 
-		return
+		return append(moves, MoveRefreshKey), nil
 	}
 
 	if lobby.game != nil {
 		moves, data := lobby.game.LegalMoves(client)
 		if lobby.Client(client).Leader {
-			moves = append(moves, MoveReturn)
+			moves = append(moves, MoveReturn, MoveVoiceMute)
 		}
 
-		return moves, data
+		return append(moves, MoveRefreshKey), data
 	}
 
 	if lobby.Client(client).Leader {
@@ -242,15 +467,67 @@ func (lm *LobbyManager) LegalMoves(client *Client) (moves []string, extra map[st
 			moves = append(moves, MoveStart)
 		}
 
-		return append(moves, MoveAddBot, MoveKick, MoveTransfer, MoveSelect, MoveRename, MoveDisconnect), nil
+		return append(moves, MoveAddBot, MoveKick, MoveTransfer, MoveSelect, MoveRename, MoveDisconnect, MoveRefreshKey, MoveVoiceMute), map[string]interface{}{
+			"disabled_games": disabledGameNames(),
+		}
 	}
 
-	return []string{MoveRename, MoveDisconnect}, nil
+	return []string{MoveRename, MoveDisconnect, MoveRefreshKey}, nil
 }
 
+// Executes moves sent by a client. Moves of clients in a lobby run on the lobby's goroutine,
+// and are checked against the client's legal moves there so nothing can change in between.
+// Must not be called from a lobby's goroutine
 func (lm *LobbyManager) ExecuteMoves(client *Client, moves []string, data interface{}) error {
+	lm.moves.RLock()
+	defer lm.moves.RUnlock()
+
+	lobby := lm.Lobby(client)
+	if lobby == nil {
+		if !client.legal(moves) {
+			return errIllegalMove
+		}
+
+		err := lm.executeOutside(client, moves, data)
+		logMove(slog.With("game", "lobby"), "lobby", moves, err)
+		return err
+	}
+
+	return lobby.call(func() error {
+		// The client may have left while the moves were waiting
+		if lobby.Client(client) == nil {
+			return errors.New("you are no longer in this lobby")
+		}
+
+		if !client.legal(moves) {
+			return errIllegalMove
+		}
+
+		return lm.execute(lobby, client, moves, data)
+	})
+}
+
+// Logs a move and counts it if it succeeded
+func logMove(logger *slog.Logger, game string, moves []string, err error) {
+	if err != nil {
+		logger.Debug("move failed", "move", moves, "err", err)
+		return
+	}
+
+	logger.Debug("move executed", "move", moves)
+	for _, m := range moves {
+		metricMovesExecuted.WithLabelValues(game, moveType(game, m)).Inc()
+	}
+}
+
+// Executes the moves of a client who isn't in a lobby, which get them into one
+func (lm *LobbyManager) executeOutside(client *Client, moves []string, data interface{}) error {
 	switch moves[0] {
 	case MoveJoin:
+		if lm.Draining() {
+			return errors.New("the server is shutting down, try again later")
+		}
+
 		lobbyID, _ := Get[string](data, "lobby")
 		name, _ := Get[string](data, "name")
 		name = cleanName(name)
@@ -263,6 +540,10 @@ func (lm *LobbyManager) ExecuteMoves(client *Client, moves []string, data interf
 			return errors.New("you must specify a name")
 		}
 
+		if !lm.owners.Owns(lobbyID) {
+			return errLobbyElsewhere
+		}
+
 		lc := &LobbyClient{
 			Client:       client,
 			Name:         name,
@@ -272,92 +553,128 @@ func (lm *LobbyManager) ExecuteMoves(client *Client, moves []string, data interf
 			Disconnected: false,
 		}
 
-		entry, ok := lm.Lobbies.Load(lobbyID)
-		var lobby *Lobby
-		if ok {
-			lobby = entry.(*Lobby)
-		} else {
-			lc.Leader = true
-			lobby = &Lobby{
-				ID:      lobbyID,
-				Clients: make(map[string]*LobbyClient),
+		// The lobby may be deleted before the join runs on it, in which case it is created again
+		for {
+			lobby, err := lm.loadOrCreateLobby(lobbyID, client.ip)
+			if err != nil {
+				return err
 			}
-			lm.Lobbies.Store(lobbyID, lobby)
-		}
-
-		lobby.mu.Lock()
-		defer lobby.mu.Unlock()
-
-		if lobby.game != nil {
-			return errors.New("you cannot join a game in progress")
-		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"client_id": lc.ID,
-			"lobby_id":  lobby.ID,
-		})
-
-		tokenString, err := token.SignedString(sharedKey)
-		if err != nil {
-			return err
+			err = lobby.call(func() error { return lm.join(lobby, lc) })
+			if err != errLobbyGone {
+				return err
+			}
 		}
 
-		lc.chatKey = tokenString
-
-		lobby.Clients[lc.ID] = lc
-		lm.clientToLobby.Store(client, lobbyID)
-		lobby.Sync()
-
 	case MoveReconnect:
 		lobbyID, _ := Get[string](data, "id")
 		clientID, _ := Get[string](data, "me")
 
+		if !lm.owners.Owns(lobbyID) {
+			return errLobbyElsewhere
+		}
+
 		entry, ok := lm.Lobbies.Load(lobbyID)
 		if !ok {
-			return errors.New("lobby no longer exists")
+			return errLobbyGone
 		}
 
 		lobby := entry.(*Lobby)
-		lobby.mu.Lock()
-		defer lobby.mu.Unlock()
+		return lobby.call(func() error { return lm.reconnect(lobby, client, clientID) })
+	}
 
-		if lobby.game == nil {
-			return errors.New("game has ended")
-		}
+	return nil
+}
 
-		lc, ok := lobby.Clients[clientID]
-		if !ok {
-			return errors.New("invalid client ID")
+// Adds the client to the lobby. Must run on the lobby's goroutine
+func (lm *LobbyManager) join(lobby *Lobby, lc *LobbyClient) (err error) {
+	// Don't leave behind a lobby that was created for a join which failed
+	defer func() {
+		if err != nil && len(lobby.Clients) == 0 {
+			lm.deleteLobby(lobby)
 		}
+	}()
 
-		if !lc.Disconnected {
-			return errors.New("you are already connected")
-		}
+	if lobby.game != nil {
+		return errors.New("you cannot join a game in progress")
+	}
 
-		lc.Client = client
-		lc.Disconnected = false
-		lm.clientToLobby.Store(client, lobbyID)
+	if lm.full(lobby) {
+		return errors.New("lobby is full")
+	}
 
-		allConnected := true
-		for _, c := range lobby.Clients {
-			if c.Disconnected {
-				allConnected = false
-				break
-			}
-		}
+	key, err := issueKey(lobby.ID, lc.ID)
+	if err != nil {
+		return err
+	}
+
+	lc.chatKey = key
+	// Lobbies without humans are deleted, so whoever joins an empty lobby created it
+	lc.Leader = len(lobby.Clients) == 0
+
+	lobby.Clients[lc.ID] = lc
+	lobby.members.Store(lc.ID, true)
+	lm.clientToLobby.Store(lc.Client, lobby.ID)
+	lobby.Announce("%s joined the lobby", lc.Name)
+	lobby.Sync()
+	return nil
+}
+
+// Gives a disconnected player's seat to the client. Must run on the lobby's goroutine
+func (lm *LobbyManager) reconnect(lobby *Lobby, client *Client, clientID string) error {
+	if lobby.game == nil {
+		return errors.New("game has ended")
+	}
+
+	lc, ok := lobby.Clients[clientID]
+	if !ok {
+		return errors.New("invalid client ID")
+	}
+
+	if !lc.Disconnected {
+		return errors.New("you are already connected")
+	}
+
+	// The previous key may have expired while the client was gone
+	key, err := issueKey(lobby.ID, lc.ID)
+	if err != nil {
+		return err
+	}
+
+	lm.clientToLobby.Delete(lc.Client)
+	lc.Client = client
+	lc.Disconnected = false
+	lc.chatKey = key
+	lm.clientToLobby.Store(client, lobby.ID)
 
-		if allConnected {
-			lobby.Frozen = false
+	allConnected := true
+	for _, c := range lobby.Clients {
+		if c.Disconnected {
+			allConnected = false
+			break
 		}
+	}
 
-		lobby.Sync()
+	if allConnected {
+		lobby.Frozen = false
+	}
+
+	lobby.Announce("%s reconnected", lc.Name)
+	lobby.Sync()
+	return nil
+}
 
+// Executes the moves of a client in the lobby. Must run on the lobby's goroutine
+func (lm *LobbyManager) execute(lobby *Lobby, client *Client, moves []string, data interface{}) (err error) {
+	game := lobby.gameName()
+	logger := lobby.logger().With("client", lobby.Client(client).ID)
+	defer func() { logMove(logger, game, moves, err) }()
+
+	switch moves[0] {
 	case MoveDisconnect:
-		lobby := lm.Lobby(client)
-		lobby.mu.Lock()
+		lobby.Announce("%s left the lobby", lobby.Client(client).Name)
 		lm.remove(lobby, client)
 		lobby.Sync()
-		lobby.mu.Unlock()
 		client.Sync()
 
 	case MoveSelect:
@@ -367,16 +684,17 @@ func (lm *LobbyManager) ExecuteMoves(client *Client, moves []string, data interf
 			return errors.New("invalid game")
 		}
 
-		lobby := lm.Lobby(client)
-		lobby.mu.Lock()
+		if !gameEnabled(g) {
+			return errors.New("this game is currently disabled")
+		}
+
 		lobby.Game = &g
 		lobby.Sync()
-		lobby.mu.Unlock()
 
 	case MoveStart:
-		lobby := lm.Lobby(client)
-		lobby.mu.Lock()
-		defer lobby.mu.Unlock()
+		if !gameEnabled(*lobby.Game) {
+			return errors.New("this game is currently disabled")
+		}
 
 		g := GAMES[*lobby.Game]
 		if len(lobby.Clients) < g.MinPlayers {
@@ -387,6 +705,7 @@ func (lm *LobbyManager) ExecuteMoves(client *Client, moves []string, data interf
 		}
 
 		lobby.game = g.Create(lobby)
+		lobby.Announce("The game has started")
 		lobby.Sync()
 
 	case MoveRename:
@@ -396,17 +715,10 @@ func (lm *LobbyManager) ExecuteMoves(client *Client, moves []string, data interf
 			return errors.New("no name provided")
 		}
 
-		lobby := lm.Lobby(client)
-		lobby.mu.Lock()
 		lobby.Client(client).Name = name
 		lobby.Sync()
-		lobby.mu.Unlock()
 
 	case MoveKick:
-		lobby := lm.Lobby(client)
-		lobby.mu.Lock()
-		defer lobby.mu.Unlock()
-
 		id, _ := Get[string](data, "id")
 
 		if id == lobby.Client(client).ID {
@@ -418,17 +730,9 @@ func (lm *LobbyManager) ExecuteMoves(client *Client, moves []string, data interf
 			return errors.New("invalid ID provided")
 		}
 
-		lm.remove(lobby, target.Client)
-		lobby.Sync()
-
-		target.SendError(errors.New("you have been kicked"))
-		target.Sync()
+		lm.kick(lobby, target)
 
 	case MoveTransfer:
-		lobby := lm.Lobby(client)
-		lobby.mu.Lock()
-		defer lobby.mu.Unlock()
-
 		id, _ := Get[string](data, "id")
 
 		target, ok := lobby.Clients[id]
@@ -449,23 +753,16 @@ func (lm *LobbyManager) ExecuteMoves(client *Client, moves []string, data interf
 		lobby.Sync()
 
 	case MoveReturn:
-		lobby := lm.Lobby(client)
-		lobby.mu.Lock()
-		defer lobby.mu.Unlock()
-		lobby.game = nil
-		lobby.Frozen = false
+		lm.endGame(lobby)
 
-		for id, c := range lobby.Clients {
-			if c.Disconnected {
-				delete(lobby.Clients, id)
-			}
+	case MoveAddBot:
+		if lm.full(lobby) || len(lobby.Clients) >= lobby.maxGamePlayers() {
+			return errors.New("lobby is full")
 		}
 
-		lobby.Sync()
-
-	case MoveAddBot:
-		lobby := lm.Lobby(client)
-		lobby.mu.Lock()
+		if lm.maxBots > 0 && lobby.botCount() >= lm.maxBots {
+			return fmt.Errorf("lobbies cannot have more than %d bots", lm.maxBots)
+		}
 
 		lc := &LobbyClient{
 			Client: &Client{
@@ -481,42 +778,67 @@ func (lm *LobbyManager) ExecuteMoves(client *Client, moves []string, data interf
 		}
 
 		lobby.Clients[lc.ID] = lc
+		lobby.members.Store(lc.ID, true)
 		lm.clientToLobby.Store(lc.Client, lobby.ID)
+		lobby.Announce("%s joined the lobby", lc.Name)
 		lobby.Sync()
-		lobby.mu.Unlock()
-		lm.RunBotRoutine(lc.Client)
+		lm.RunBotRoutine(lobby, lc)
+
+	case MoveVoiceMute:
+		id, _ := Get[string](data, "id")
+		muted, _ := Get[bool](data, "muted")
+
+		target, ok := lobby.Clients[id]
+		if !ok {
+			return errors.New("invalid ID provided")
+		}
+
+		if target.Client == client {
+			return errors.New("cannot server mute yourself")
+		}
+
+		target.Voice.ServerMuted = muted
+		if muted {
+			target.Voice.Speaking = false
+		}
+
+		lobby.voice.setStatus(target.ID, target.Voice)
+		lobby.Sync()
+
+	case MoveRefreshKey:
+		lc := lobby.Client(client)
+		key, err := issueKey(lobby.ID, lc.ID)
+		if err != nil {
+			return err
+		}
+
+		lc.chatKey = key
+		client.Sync()
 
 	default:
-		lobby := lm.Lobby(client)
-		if lobby != nil {
-			lobby.mu.Lock()
-			defer func() {
-				if r := recover(); r != nil {
-					fmt.Println("error encountered in", *lobby.Game)
-					if err, ok := r.(error); ok {
-						fmt.Println(err.Error())
-					} else {
-						fmt.Println(r)
-					}
-
-					lobby.game = nil
-					for _, c := range lobby.Clients {
-						c.SendError(errors.New("the game has encountered an error"))
-					}
+		defer func() {
+			if r := recover(); r != nil {
+				metricRecoveredPanics.WithLabelValues(*lobby.Game).Inc()
+				logger.Error("game panicked", "move", moves, "panic", r, "stack", string(debug.Stack()))
+
+				lobby.game = nil
+				lobby.Announce("The game has encountered an error")
+				for _, c := range lobby.Clients {
+					c.SendError(errors.New("the game has encountered an error"))
 				}
-				lobby.Sync()
-				lobby.mu.Unlock()
-			}()
-
-			if lobby.game != nil {
-				return lobby.game.ExecuteMoves(client, moves, data)
 			}
+			lobby.Sync()
+		}()
+
+		if lobby.game != nil {
+			return lobby.game.ExecuteMoves(client, moves, data)
 		}
 	}
 
 	return nil
 }
 
+// Must run on the goroutine of the client's lobby, if they are in one
 func (lm *LobbyManager) State(client *Client) interface{} {
 	lobby := lm.Lobby(client)
 	if lobby == nil {
@@ -530,17 +852,27 @@ func (lm *LobbyManager) State(client *Client) interface{} {
 	return lobby.State(client)
 }
 
+// Handles the client's connection closing. The lobby hears about it on its own goroutine,
+// so this may be called from anywhere, including while the lobby syncs the client
 func (lm *LobbyManager) Disconnect(client *Client) {
 	lobby := lm.Lobby(client)
 	if lobby == nil {
 		return
 	}
 
-	lobby.mu.Lock()
-	defer func() {
-		lobby.Sync()
-		lobby.mu.Unlock()
-	}()
+	lobby.post(func() { lm.disconnect(lobby, client) })
+}
+
+// Must run on the lobby's goroutine
+func (lm *LobbyManager) disconnect(lobby *Lobby, client *Client) {
+	lc := lobby.Client(client)
+	// The client may have left or been kicked in the meantime
+	if lc == nil {
+		return
+	}
+	defer lobby.Sync()
+
+	lobby.Announce("%s disconnected", lc.Name)
 
 	if lobby.game == nil {
 		lm.remove(lobby, client)
@@ -553,7 +885,7 @@ func (lm *LobbyManager) Disconnect(client *Client) {
 	}
 
 	lobby.Frozen = true
-	lobby.Client(client).Disconnected = true
+	lc.Disconnected = true
 
 	// Delete lobby if every human is disconnected
 	someone := false
@@ -565,6 +897,6 @@ func (lm *LobbyManager) Disconnect(client *Client) {
 	}
 
 	if !someone {
-		lm.Lobbies.Delete(lobby.ID)
+		lm.deleteLobby(lobby)
 	}
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Discards everything sent to the client
+type nopChannel struct{}
+
+func (nopChannel) Send(data []byte) error {
+	return nil
+}
+
+func (nopChannel) Close() error {
+	return nil
+}
+
+func newTestLobbyManager(t *testing.T) (*LobbyManager, *GameServer) {
+	config := DefaultConfig()
+	config.BotInterval = time.Millisecond
+	config.MaxLobbiesPerIP = 0
+
+	cluster, err := NewCluster(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lm, err := NewLobbyManager(config, cluster, NewLocalPubSub())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return lm, NewGameServer(lm)
+}
+
+// Many clients join a few lobbies at once, make moves in them and disconnect, while bots play.
+// Run with -race
+func TestLobbyConcurrentClients(t *testing.T) {
+	lm, server := newTestLobbyManager(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			c := server.newClient(nopChannel{}, fmt.Sprintf("10.0.0.%d", i))
+			c.Sync()
+
+			err := lm.ExecuteMoves(c, []string{MoveJoin}, map[string]interface{}{
+				"lobby": fmt.Sprintf("lobby-%d", i%4),
+				"name":  fmt.Sprintf("Player %d", i),
+			})
+			if err != nil {
+				c.Disconnect()
+				return
+			}
+
+			// Most of these are only legal for the leader, and may fail once a game starts
+			lm.ExecuteMoves(c, []string{MoveRename}, map[string]interface{}{"name": fmt.Sprintf("Renamed %d", i)})
+			lm.ExecuteMoves(c, []string{MoveSelect}, map[string]interface{}{"game": "uno"})
+			lm.ExecuteMoves(c, []string{MoveAddBot}, nil)
+			lm.ExecuteMoves(c, []string{MoveStart}, nil)
+
+			time.Sleep(time.Duration(i%5) * 10 * time.Millisecond)
+			c.Disconnect()
+		}(i)
+	}
+	wg.Wait()
+
+	// Lobbies are deleted on their own goroutines once their last human leaves
+	deadline := time.Now().Add(5 * time.Second)
+	for lm.lobbyCount() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d lobbies left after every client disconnected", lm.lobbyCount())
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Deleting a lobby forgets every client in it, bots included
+	lm.clientToLobby.Range(func(client, lobbyID interface{}) bool {
+		t.Errorf("client still mapped to lobby %v", lobbyID)
+		return true
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Whether chat messages and voice signaling payloads are logged as they are
+var logPayloads bool
+
+// Replaces the payload unless payload logging is turned on
+func redact(payload string) string {
+	if logPayloads {
+		return payload
+	}
+
+	return "[redacted]"
+}
+
+// Sets the default logger from the config. The log package writes through it as well
+func setupLogging(config *Config) {
+	var level slog.Level
+	// Already checked by Validate
+	level.UnmarshalText([]byte(config.LogLevel))
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if strings.EqualFold(config.LogFormat, "json") {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	logPayloads = config.LogPayloads
+}
+
+// Returns a logger with the lobby and game. Must run on the lobby's goroutine
+func (l *Lobby) logger() *slog.Logger {
+	return slog.With("lobby", l.ID, "game", l.gameName())
+}
+
+// Returns a logger with the client's lobby, ID and game. Must run on the goroutine of the
+// client's lobby, if they are in one
+func (lm *LobbyManager) logger(client *Client) *slog.Logger {
+	if lobby := lm.Lobby(client); lobby != nil {
+		if lc := lobby.Client(client); lc != nil {
+			return lobby.logger().With("client", lc.ID)
+		}
+	}
+
+	return slog.With("game", "lobby")
+}
+
+// Returns the logger saved by the last Sync, so rejecting a packet never waits on the lobby
+func (c *Client) logger() *slog.Logger {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.log == nil {
+		return slog.Default()
+	}
+
+	return c.log
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Returned by calls to a lobby that has been deleted
+var errLobbyGone = errors.New("lobby no longer exists")
+
+// Returned by calls that panicked. The panic itself is logged
+var errLobbyPanicked = errors.New("something went wrong, try again")
+
+// Runs the functions posted to it one at a time on a single goroutine, in the order they
+// were posted. Everything a lobby owns is only touched from its mailbox's goroutine, so
+// moves, disconnects, timers and bots never need a lock
+type mailbox struct {
+	mu      sync.Mutex // Protect queue and stopped
+	queue   []func(stopped bool)
+	stopped bool
+	wake    chan struct{}
+}
+
+func newMailbox() *mailbox {
+	return &mailbox{
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Queues f without waiting for it to run. The queue is unbounded so posting never blocks,
+// even from the mailbox's own goroutine
+func (m *mailbox) enqueue(f func(stopped bool)) bool {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return false
+	}
+	m.queue = append(m.queue, f)
+	m.mu.Unlock()
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// Runs f on the mailbox's goroutine. Returns false if the mailbox was stopped
+func (m *mailbox) post(f func()) bool {
+	return m.enqueue(func(stopped bool) {
+		if !stopped {
+			f()
+		}
+	})
+}
+
+// Runs f on the mailbox's goroutine and waits for it to return. Must not be called from
+// the mailbox's own goroutine, which would wait on itself forever
+func (m *mailbox) call(f func() error) error {
+	done := make(chan error, 1)
+	ok := m.enqueue(func(stopped bool) {
+		if stopped {
+			done <- errLobbyGone
+			return
+		}
+
+		err := errLobbyPanicked
+		defer func() { done <- err }()
+		err = f()
+	})
+	if !ok {
+		return errLobbyGone
+	}
+
+	return <-done
+}
+
+// Posts f once d has passed
+func (m *mailbox) after(d time.Duration, f func()) {
+	time.AfterFunc(d, func() { m.post(f) })
+}
+
+// Stops accepting functions. Anything already queued is skipped and calls waiting on it
+// return errLobbyGone. Safe to call from the mailbox's goroutine
+func (m *mailbox) stop() {
+	m.mu.Lock()
+	m.stopped = true
+	m.mu.Unlock()
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Runs queued functions until the mailbox is stopped and its queue is empty
+func (m *mailbox) run() {
+	for range m.wake {
+		for {
+			m.mu.Lock()
+			if len(m.queue) == 0 {
+				stopped := m.stopped
+				m.mu.Unlock()
+				if stopped {
+					return
+				}
+
+				break
+			}
+
+			f := m.queue[0]
+			m.queue[0] = nil
+			m.queue = m.queue[1:]
+			stopped := m.stopped
+			m.mu.Unlock()
+
+			m.handle(f, stopped)
+		}
+	}
+}
+
+func (m *mailbox) handle(f func(stopped bool), stopped bool) {
+	start := time.Now()
+	defer func() {
+		metricLobbyMessage.Observe(time.Since(start).Seconds())
+
+		// A panic must not take the goroutine down with it, or every later call would hang
+		if r := recover(); r != nil {
+			metricRecoveredPanics.WithLabelValues("lobby").Inc()
+			slog.Error("recovered panic", "handler", "lobby", "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+
+	f(stopped)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startMailbox(t *testing.T) *mailbox {
+	m := newMailbox()
+	go m.run()
+	t.Cleanup(m.stop)
+	return m
+}
+
+func TestMailboxCall(t *testing.T) {
+	m := startMailbox(t)
+
+	want := errors.New("failed")
+	if err := m.call(func() error { return want }); err != want {
+		t.Fatalf("call returned %v, want %v", err, want)
+	}
+
+	// Posts run before later calls, in order
+	order := []int{}
+	for i := 0; i < 100; i++ {
+		i := i
+		m.post(func() { order = append(order, i) })
+	}
+
+	m.call(func() error { return nil })
+	for i, n := range order {
+		if n != i {
+			t.Fatalf("post %d ran as number %d", n, i)
+		}
+	}
+
+	if len(order) != 100 {
+		t.Fatalf("%d of 100 posts ran", len(order))
+	}
+}
+
+func TestMailboxCallConcurrent(t *testing.T) {
+	m := startMailbox(t)
+
+	// Only touched on the mailbox's goroutine, so -race catches any overlap
+	count := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				m.call(func() error {
+					count++
+					return nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	m.call(func() error {
+		if count != 1000 {
+			t.Errorf("count is %d, want 1000", count)
+		}
+		return nil
+	})
+}
+
+func TestMailboxAfter(t *testing.T) {
+	m := startMailbox(t)
+
+	start := time.Now()
+	ran := make(chan time.Duration, 1)
+	m.after(50*time.Millisecond, func() { ran <- time.Since(start) })
+
+	select {
+	case d := <-ran:
+		if d < 50*time.Millisecond {
+			t.Fatalf("ran after %v, want at least 50ms", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("never ran")
+	}
+}
+
+func TestMailboxAfterStopped(t *testing.T) {
+	m := startMailbox(t)
+
+	ran := make(chan struct{}, 1)
+	m.after(20*time.Millisecond, func() { ran <- struct{}{} })
+	m.stop()
+
+	select {
+	case <-ran:
+		t.Fatal("ran after the mailbox was stopped")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMailboxStop(t *testing.T) {
+	m := newMailbox()
+
+	// Nothing runs until run starts, so these are still queued when the mailbox stops
+	ran := false
+	m.post(func() { ran = true })
+
+	waiting := make(chan error, 1)
+	queued := make(chan struct{})
+	go func() {
+		close(queued)
+		waiting <- m.call(func() error {
+			ran = true
+			return nil
+		})
+	}()
+	<-queued
+	// Give the call time to be queued
+	time.Sleep(20 * time.Millisecond)
+
+	m.stop()
+	done := make(chan struct{})
+	go func() {
+		m.run()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("run didn't return after stop")
+	}
+
+	if err := <-waiting; err != errLobbyGone {
+		t.Fatalf("queued call returned %v, want errLobbyGone", err)
+	}
+
+	if ran {
+		t.Fatal("queued function ran after stop")
+	}
+
+	if m.post(func() {}) {
+		t.Fatal("post succeeded after stop")
+	}
+
+	if err := m.call(func() error { return nil }); err != errLobbyGone {
+		t.Fatalf("call returned %v after stop, want errLobbyGone", err)
+	}
+}
+
+func TestMailboxStopFromOwnGoroutine(t *testing.T) {
+	m := newMailbox()
+	done := make(chan struct{})
+	go func() {
+		m.run()
+		close(done)
+	}()
+
+	if err := m.call(func() error {
+		m.stop()
+		return nil
+	}); err != nil {
+		t.Fatalf("call returned %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("run didn't return after stop")
+	}
+}
+
+func TestMailboxPanic(t *testing.T) {
+	m := startMailbox(t)
+
+	if err := m.call(func() error { panic("boom") }); err != errLobbyPanicked {
+		t.Fatalf("panicking call returned %v, want errLobbyPanicked", err)
+	}
+
+	m.post(func() { panic("boom") })
+
+	// The goroutine survived both panics
+	if err := m.call(func() error { return nil }); err != nil {
+		t.Fatalf("call after panic returned %v", err)
+	}
+}
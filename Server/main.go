@@ -1,34 +1,160 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
-	"log"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var sharedKey = make([]byte, 64)
 
 func main() {
-	rand.Read(sharedKey)
+	config, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		slog.Error("load config", "err", err)
+		os.Exit(1)
+	}
+	setupLogging(config)
+	config.Print()
+
+	if config.JWTSecret != "" {
+		sharedKey = []byte(config.JWTSecret)
+	} else {
+		rand.Read(sharedKey)
+	}
+
+	allowedOrigins = config.AllowedOrigins
+	connLimiter = NewIPLimiter(config.MaxConnsPerIP)
+	idleTimeout = config.IdleTimeout
 
-	gameServer := NewGameServer(&LobbyManager{})
+	filter := NewWordFilter(nil)
+	if config.ChatFilterFile != "" {
+		f, err := LoadWordFilter(config.ChatFilterFile)
+		if err != nil {
+			slog.Error("load word filter", "err", err)
+			os.Exit(1)
+		}
+		filter = f
+	}
+
+	cluster, err := NewCluster(config)
+	if err != nil {
+		slog.Error("cluster", "err", err)
+		os.Exit(1)
+	}
+	clusterSecret = config.ClusterSecret
+
+	lobbies, err := NewLobbyManager(config, cluster, NewLocalPubSub())
+	if err != nil {
+		slog.Error("lobbies", "err", err)
+		os.Exit(1)
+	}
+	gameServer := NewGameServer(lobbies)
+	chatServer := NewChatServer(lobbies, filter)
+	voiceServer := NewVoiceServer(lobbies)
+	muxServer := NewMuxServer(gameServer, chatServer, voiceServer)
+	registerLobbyMetrics()
 
 	mux := http.NewServeMux()
-	mux.Handle("/", http.FileServer(http.Dir("dist/")))
-	mux.HandleFunc("/ws", gameServer.handleWs)
-	mux.HandleFunc("/chat", handleChatWs)
-	mux.HandleFunc("/voice", handleVoiceWs)
+	mux.Handle("/", http.FileServer(http.Dir(config.StaticDir)))
+	mux.HandleFunc("/ws", cluster.route(gameServer.handleWs))
+	mux.HandleFunc("/chat", cluster.route(chatServer.handleChatWs))
+	mux.HandleFunc("/voice", cluster.route(voiceServer.handleVoiceWs))
+	mux.HandleFunc("/mux", cluster.route(muxServer.handleMuxWs))
+	mux.Handle("/metrics", promhttp.Handler())
+
+	if config.AdminToken != "" {
+		adminServer := NewAdminServer(lobbies, config.AdminToken)
+		mux.HandleFunc("/admin/", adminServer.handleDashboard)
+		mux.HandleFunc("/admin/api/", adminServer.handleAPI)
+	}
 
 	server := &http.Server{
-		Addr:           ":8080",
+		Addr:           config.Addr,
 		Handler:        mux,
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
+		ReadTimeout:    config.ReadTimeout,
+		WriteTimeout:   config.WriteTimeout,
 		MaxHeaderBytes: 1 << 20,
 	}
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal("ListenAndServe: ", err)
+	servers := []*http.Server{server}
+	listen := server.ListenAndServe
+	if config.TLSCertFile != "" {
+		certs, err := newCertReloader(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			slog.Error("load certificate", "err", err)
+			os.Exit(1)
+		}
+
+		server.TLSConfig = &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+		listen = func() error { return server.ListenAndServeTLS("", "") }
+
+		if config.RedirectAddr != "" {
+			redirect := &http.Server{
+				Addr:         config.RedirectAddr,
+				Handler:      redirectToHTTPS(config.Addr),
+				ReadTimeout:  config.ReadTimeout,
+				WriteTimeout: config.WriteTimeout,
+			}
+			servers = append(servers, redirect)
+			go serve(redirect.ListenAndServe)
+		}
 	}
+
+	go serve(listen)
+	slog.Info("listening", "addr", config.Addr, "tls", config.TLSCertFile != "", "redirect_addr", config.RedirectAddr)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	shutdown(lobbies, config, servers...)
+}
+
+// Exits if the server can't listen
+func serve(listen func() error) {
+	if err := listen(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("listen and serve", "err", err)
+		os.Exit(1)
+	}
+}
+
+// Warns players, stops accepting connections, waits for moves to finish and saves every lobby
+func shutdown(lobbies *LobbyManager, config *Config, servers ...*http.Server) {
+	slog.Info("shutting down", "grace", config.ShutdownGrace)
+	lobbies.Drain()
+	lobbies.Countdown(config.ShutdownGrace)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("shutdown", "addr", server.Addr, "err", err)
+		}
+	}
+
+	lobbies.closeAllChatAndVoice()
+	lobbies.StopMoves()
+
+	if err := lobbies.WriteSnapshots(config.SnapshotDir); err != nil {
+		slog.Error("write snapshots", "err", err)
+	}
+
+	slog.Info("shutdown done")
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricConnectedClients = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cg_connected_clients",
+		Help: "Number of open sockets by socket type.",
+	}, []string{"socket"})
+
+	metricMovesExecuted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cg_moves_executed_total",
+		Help: "Number of moves executed successfully by game and move type.",
+	}, []string{"game", "move"})
+
+	metricRejectedPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cg_rejected_packets_total",
+		Help: "Number of packets from game sockets rejected before reaching the game, by reason.",
+	}, []string{"reason"})
+
+	metricRecoveredPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cg_recovered_panics_total",
+		Help: "Number of panics recovered by where they were recovered.",
+	}, []string{"where"})
+
+	metricPatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cg_patch_size_bytes",
+		Help:    "Size of merge patches sent to clients.",
+		Buckets: prometheus.ExponentialBuckets(16, 4, 8),
+	})
+
+	metricLobbyMessage = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cg_lobby_message_seconds",
+		Help:    "How long lobby goroutines take to handle a message from their mailbox.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+	})
+)
+
+// Lobbies add themselves to these from their own goroutines whenever their game or bots
+// change, so scrapes never wait on a lobby
+var (
+	metricActiveLobbies = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cg_active_lobbies",
+		Help: "Number of lobbies by the game being played, lobby if none.",
+	}, []string{"game"})
+
+	metricBots = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cg_bots",
+		Help: "Number of bots in lobbies.",
+	})
+)
+
+// Reports every game, even while nobody is playing it
+func registerLobbyMetrics() {
+	for name := range GAMES {
+		metricActiveLobbies.WithLabelValues(name)
+	}
+	metricActiveLobbies.WithLabelValues("lobby")
+}
+
+// What a lobby last added to the lobby gauges
+type lobbyMetrics struct {
+	game    string
+	bots    int
+	counted bool
+	deleted bool
+}
+
+// Moves the lobby to its current game in the gauges. Must run on the lobby's goroutine
+func (l *Lobby) reportMetrics() {
+	game, bots := l.gameName(), l.botCount()
+	m := &l.metrics
+	if m.deleted || (m.counted && m.game == game && m.bots == bots) {
+		return
+	}
+
+	l.forgetMetrics()
+	metricActiveLobbies.WithLabelValues(game).Inc()
+	metricBots.Add(float64(bots))
+	*m = lobbyMetrics{game: game, bots: bots, counted: true}
+}
+
+// Takes the lobby out of the gauges. Must run on the lobby's goroutine
+func (l *Lobby) forgetMetrics() {
+	m := &l.metrics
+	if m.counted {
+		metricActiveLobbies.WithLabelValues(m.game).Dec()
+		metricBots.Sub(float64(m.bots))
+		m.counted = false
+	}
+}
+
+// Groups moves so card moves like "12", "58_3", "QH" or "8H_S", Spades bids like "4" and asks like
+// "ask_<id>_Q" don't each get their own label
+func moveType(game, move string) string {
+	if move == "" || strings.HasPrefix(move, "lobby.") {
+		return move
+	}
+
+	// Other games use plain numbers for cards
+	if _, err := strconv.Atoi(move); err == nil && game == "spades" {
+		return "bid"
+	}
+
+	if strings.HasPrefix(move, moveAsk+"_") {
+		return moveAsk
+	}
+
+	if unicode.IsDigit(rune(move[0])) {
+		return "card"
+	}
+
+	code, _, _ := strings.Cut(move, "_")
+	if c, err := ParseCardCode(code); err == nil && c.String() == code {
+		return "card"
+	}
+
+	return move
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+type MuxChannelName string
+
+const (
+	MuxChannelGame  MuxChannelName = "game"
+	MuxChannelChat  MuxChannelName = "chat"
+	MuxChannelVoice MuxChannelName = "voice"
+)
+
+type MuxOp string
+
+const (
+	MuxOpMessage MuxOp = ""      // A regular message for the channel
+	MuxOpOpen    MuxOp = "open"  // Client->Server: attach to the lobby's chat or voice
+	MuxOpClose   MuxOp = "close" // Either direction: the chat or voice channel is no longer attached
+)
+
+// A frame sent over the multiplexed socket. The payload of game frames is a packet
+// (or merge patch), chat payloads are strings from the client and chat messages from the
+// server, and voice payloads are voice messages
+type MuxFrame struct {
+	Channel MuxChannelName  `json:"channel"`
+	Op      MuxOp           `json:"op,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+var errChannelClosed = errors.New("channel closed")
+
+// A websocket shared by the game, chat and voice channels of one client
+type muxConn struct {
+	mu   sync.Mutex // Protect conn writes and the closed state of channels
+	conn *websocket.Conn
+}
+
+func (mc *muxConn) write(f *MuxFrame) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	return writeMessage(mc.conn, data)
+}
+
+func (mc *muxConn) channel(name MuxChannelName) *muxChannel {
+	return &muxChannel{conn: mc, name: name}
+}
+
+type muxChannel struct {
+	conn   *muxConn
+	name   MuxChannelName
+	closed bool
+}
+
+func (c *muxChannel) Send(data []byte) error {
+	payload := json.RawMessage(data)
+	// Raw text such as the chat's "OK" is sent as a JSON string
+	if !json.Valid(data) {
+		payload, _ = json.Marshal(string(data))
+	}
+
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+	if c.closed {
+		return errChannelClosed
+	}
+
+	return c.conn.write(&MuxFrame{Channel: c.name, Payload: payload})
+}
+
+// Closing the game channel closes the whole socket, closing chat or voice only detaches them
+func (c *muxChannel) Close() error {
+	if c.name == MuxChannelGame {
+		return c.conn.conn.Close()
+	}
+
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	return c.conn.write(&MuxFrame{Channel: c.name, Op: MuxOpClose})
+}
+
+func (c *muxChannel) isClosed() bool {
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+	return c.closed
+}
+
+// Serves the game, chat and voice over a single socket. Chat and voice are authenticated
+// by the lobby the client has joined through the game channel, so no keys are needed
+type MuxServer struct {
+	game  *GameServer
+	chat  *ChatServer
+	voice *VoiceServer
+}
+
+func NewMuxServer(game *GameServer, chat *ChatServer, voice *VoiceServer) *MuxServer {
+	return &MuxServer{
+		game:  game,
+		chat:  chat,
+		voice: voice,
+	}
+}
+
+// The chat and voice sessions of a multiplexed connection. Only used by the reading goroutine
+type muxSessions struct {
+	chat      *chatSession
+	chatChan  *muxChannel
+	voice     *voiceSession
+	voiceChan *muxChannel
+}
+
+func (s *MuxServer) handleMuxWs(w http.ResponseWriter, r *http.Request) {
+	defer logRecover("handleMuxWs")
+
+	ip, ok := acquireConn(w, r)
+	if !ok {
+		return
+	}
+	defer connLimiter.Release(ip)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("upgrade failed", "socket", "mux", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	conn.SetReadLimit(voiceReadLimit)
+
+	keepAlive := startKeepAlive(conn, idleTimeout)
+	defer keepAlive.stop()
+
+	metricConnectedClients.WithLabelValues("mux").Inc()
+	defer metricConnectedClients.WithLabelValues("mux").Dec()
+
+	mc := &muxConn{conn: conn}
+
+	c := s.game.newClient(mc.channel(MuxChannelGame), ip)
+	c.Sync()
+
+	sessions := &muxSessions{}
+	disconnect := func() {
+		if sessions.chat != nil {
+			sessions.chat.close()
+		}
+		if sessions.voice != nil {
+			sessions.voice.close()
+		}
+		c.Disconnect()
+	}
+
+	conn.SetCloseHandler(func(code int, text string) error {
+		disconnect()
+		return nil
+	})
+
+	for {
+		messageType, msg, err := conn.ReadMessage()
+		if err != nil {
+			disconnect()
+			return
+		}
+
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		keepAlive.touch()
+
+		f := &MuxFrame{}
+		if err := json.Unmarshal(msg, f); err != nil {
+			c.logger().Debug("invalid mux frame", "err", err)
+			continue
+		}
+
+		switch f.Channel {
+		case MuxChannelGame:
+			c.handleMessage(f.Payload)
+		case MuxChannelChat:
+			s.handleChatFrame(c, mc, sessions, f)
+		case MuxChannelVoice:
+			s.handleVoiceFrame(c, mc, sessions, f)
+		}
+	}
+}
+
+func (s *MuxServer) handleChatFrame(c *Client, mc *muxConn, sessions *muxSessions, f *MuxFrame) {
+	// The server may have closed the channel, e.g. if the client was kicked
+	if sessions.chat != nil && sessions.chatChan.isClosed() {
+		sessions.chat.close()
+		sessions.chat = nil
+	}
+
+	switch f.Op {
+	case MuxOpOpen:
+		if sessions.chat != nil {
+			sessions.chat.close()
+		}
+
+		sessions.chat = nil
+		sessions.chatChan = mc.channel(MuxChannelChat)
+		lobbyID, clientID, ok := s.chat.lobbies.Member(c)
+		if ok {
+			sessions.chat = s.chat.open(sessions.chatChan, lobbyID, clientID)
+		}
+
+		if sessions.chat == nil {
+			sessions.chatChan.Close()
+		}
+
+	case MuxOpClose:
+		if sessions.chat != nil {
+			sessions.chat.close()
+			sessions.chat = nil
+		}
+
+	case MuxOpMessage:
+		if sessions.chat == nil {
+			mc.channel(MuxChannelChat).Close()
+			return
+		}
+
+		var content string
+		if err := json.Unmarshal(f.Payload, &content); err != nil {
+			return
+		}
+
+		if !sessions.chat.handle([]byte(content)) {
+			sessions.chat = nil
+		}
+	}
+}
+
+func (s *MuxServer) handleVoiceFrame(c *Client, mc *muxConn, sessions *muxSessions, f *MuxFrame) {
+	// The server may have closed the channel, e.g. if the client was kicked
+	if sessions.voice != nil && sessions.voiceChan.isClosed() {
+		sessions.voice.close()
+		sessions.voice = nil
+	}
+
+	switch f.Op {
+	case MuxOpOpen:
+		if sessions.voice != nil {
+			sessions.voice.close()
+		}
+
+		sessions.voice = nil
+		sessions.voiceChan = mc.channel(MuxChannelVoice)
+		lobbyID, clientID, ok := s.voice.lobbies.Member(c)
+		if ok {
+			sessions.voice = s.voice.open(sessions.voiceChan, lobbyID, clientID)
+		}
+
+		if sessions.voice == nil {
+			sessions.voiceChan.Close()
+		}
+
+	case MuxOpClose:
+		if sessions.voice != nil {
+			sessions.voice.close()
+			sessions.voice = nil
+		}
+
+	case MuxOpMessage:
+		if sessions.voice == nil {
+			mc.channel(MuxChannelVoice).Close()
+			return
+		}
+
+		if !sessions.voice.handle(f.Payload) {
+			sessions.voice = nil
+		}
+	}
+}
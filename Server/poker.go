@@ -0,0 +1,138 @@
+package main
+
+import "sort"
+
+// Ranks poker hands of five to seven cards by their best five. Jokers aren't supported
+
+type PokerCategory int
+
+const (
+	HighCard PokerCategory = iota
+	OnePair
+	TwoPair
+	ThreeOfAKind
+	Straight
+	Flush
+	FullHouse
+	FourOfAKind
+	StraightFlush
+)
+
+var pokerCategoryNames = []string{
+	"High card",
+	"Pair",
+	"Two pair",
+	"Three of a kind",
+	"Straight",
+	"Flush",
+	"Full house",
+	"Four of a kind",
+	"Straight flush",
+}
+
+func (c PokerCategory) String() string {
+	return pokerCategoryNames[c]
+}
+
+// A hand's category followed by the ranks that break ties, packed so that a better hand
+// always has a higher value
+type PokerHand int
+
+func (h PokerHand) Category() PokerCategory {
+	return PokerCategory(h >> 20)
+}
+
+func (h PokerHand) String() string {
+	return h.Category().String()
+}
+
+// The value of the best five cards out of the given ones
+func EvaluatePoker(cards Cards) PokerHand {
+	if len(cards) <= 5 {
+		return evaluateFive(cards)
+	}
+
+	best := PokerHand(-1)
+	hand := make(Cards, 5)
+	var choose func(start int, n int)
+	choose = func(start int, n int) {
+		if n == 5 {
+			if v := evaluateFive(hand); v > best {
+				best = v
+			}
+			return
+		}
+
+		for i := start; i <= len(cards)-(5-n); i++ {
+			hand[n] = cards[i]
+			choose(i+1, n+1)
+		}
+	}
+	choose(0, 0)
+
+	return best
+}
+
+func evaluateFive(cards Cards) PokerHand {
+	counts := make(map[Rank]int)
+	flush := true
+	for _, c := range cards {
+		counts[c.Rank()]++
+		if c.Suit() != cards[0].Suit() {
+			flush = false
+		}
+	}
+
+	// Ranks ordered by how many there are, then by how high they are
+	ranks := []Rank{}
+	for r := range counts {
+		ranks = append(ranks, r)
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		if counts[ranks[i]] != counts[ranks[j]] {
+			return counts[ranks[i]] > counts[ranks[j]]
+		}
+
+		return ranks[i] > ranks[j]
+	})
+
+	straight := len(ranks) == 5 && len(cards) == 5 && ranks[0]-ranks[4] == 4
+	// The wheel, where the ace plays low
+	if len(ranks) == 5 && ranks[0] == Ace && ranks[1] == Five && ranks[4] == Two {
+		straight = true
+		ranks = append(ranks[1:], 1)
+	}
+	flush = flush && len(cards) == 5
+
+	var category PokerCategory
+	switch {
+	case straight && flush:
+		category = StraightFlush
+	case counts[ranks[0]] == 4:
+		category = FourOfAKind
+	case counts[ranks[0]] == 3 && len(ranks) > 1 && counts[ranks[1]] == 2:
+		category = FullHouse
+	case flush:
+		category = Flush
+	case straight:
+		category = Straight
+	case counts[ranks[0]] == 3:
+		category = ThreeOfAKind
+	case counts[ranks[0]] == 2 && len(ranks) > 1 && counts[ranks[1]] == 2:
+		category = TwoPair
+	case counts[ranks[0]] == 2:
+		category = OnePair
+	default:
+		category = HighCard
+	}
+
+	value := int(category)
+	for i := 0; i < 5; i++ {
+		value <<= 4
+		if i < len(ranks) {
+			value |= int(ranks[i])
+		}
+	}
+
+	return PokerHand(value)
+}
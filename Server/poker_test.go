@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Parses space separated card codes, like "AS KD TC"
+func parseHand(t *testing.T, codes string) Cards {
+	t.Helper()
+
+	cards := Cards{}
+	for _, code := range strings.Fields(codes) {
+		c, err := ParseCardCode(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cards = append(cards, c)
+	}
+
+	return cards
+}
+
+func TestEvaluatePokerCategory(t *testing.T) {
+	tests := []struct {
+		name string
+		hand string
+		want PokerCategory
+	}{
+		{"high card", "AS JD 8C 5H 2S", HighCard},
+		{"pair", "9S 9D KC 7H 2S", OnePair},
+		{"two pair", "9S 9D KC KH 2S", TwoPair},
+		{"three of a kind", "9S 9D 9C KH 2S", ThreeOfAKind},
+		{"straight", "9S TD JC QH KS", Straight},
+		{"ace high straight", "TS JD QC KH AS", Straight},
+		{"wheel", "AS 2D 3C 4H 5S", Straight},
+		{"no wraparound straight", "QS KD AC 2H 3S", HighCard},
+		{"flush", "AH JH 8H 5H 2H", Flush},
+		{"full house", "9S 9D 9C KH KS", FullHouse},
+		{"four of a kind", "9S 9D 9C 9H KS", FourOfAKind},
+		{"straight flush", "5C 6C 7C 8C 9C", StraightFlush},
+		{"royal flush", "TD JD QD KD AD", StraightFlush},
+		{"steel wheel", "AH 2H 3H 4H 5H", StraightFlush},
+
+		// Seven cards are judged by their best five
+		{"seven card high card", "AS JD 8C 5H 2S 3D 9C", HighCard},
+		{"seven card straight", "2S 3D 4C 5H 6S KD KC", Straight},
+		{"seven card wheel", "AS 2D 3C 4H 5S KD KC", Straight},
+		{"seven card flush over straight", "2H 3D 4H 5H 6S 9H KH", Flush},
+		{"two trips make a full house", "9S 9D 9C KH KS KD 2C", FullHouse},
+		{"three pairs make two pair", "9S 9D KC KH 2S 2D AC", TwoPair},
+		{"straight flush among a flush", "5C 6C 7C 8C 9C AC 2C", StraightFlush},
+		{"quads over a full house", "9S 9D 9C 9H KS KD 2C", FourOfAKind},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EvaluatePoker(parseHand(t, tt.hand)).Category()
+			if got != tt.want {
+				t.Errorf("EvaluatePoker(%s) is %v, want %v", tt.hand, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluatePokerOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		better string
+		worse  string
+	}{
+		{"pair beats high card", "2S 2D 3C 4H 6S", "AS KD QC JH 9S"},
+		{"straight flush beats four of a kind", "AH 2H 3H 4H 5H", "AS AD AC AH KS"},
+		{"higher straight", "2S 3D 4C 5H 6S", "AS 2D 3C 4H 5S"},
+		{"wheel beats three of a kind", "AS 2D 3C 4H 5S", "AS AD AC KH QS"},
+		{"higher pair", "KS KD 2C 3H 4S", "QS QD AC KH JS"},
+		{"pair kicker", "9S 9D AC 3H 2S", "9C 9H KC QH JS"},
+		{"last pair kicker", "9S 9D AC KH 3S", "9C 9H AD KC 2S"},
+		{"two pair top pair", "KS KD 2C 2H 3S", "QS QD JC JH AS"},
+		{"two pair bottom pair", "KS KD 3C 3H 2S", "KC KH 2D 2H AS"},
+		{"two pair kicker", "KS KD 3C 3H 5S", "KC KH 3D 3S 4S"},
+		{"three of a kind kicker", "7S 7D 7C AH 2S", "7S 7D 7H KH QS"},
+		{"flush last card", "AH JH 8H 5H 3H", "AS JS 8S 5S 2S"},
+		{"full house by its three", "3S 3D 3C 2H 2S", "2S 2D 2C AH AS"},
+		{"full house by its pair", "KS KD KC 3H 3S", "KS KD KC 2H 2S"},
+		{"four of a kind kicker", "9S 9D 9C 9H AS", "9S 9D 9C 9H KS"},
+		{"seven card kicker", "AS AD KC 7H 5S 3D 2C", "AS AD QC JH TS 3D 2C"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			better := EvaluatePoker(parseHand(t, tt.better))
+			worse := EvaluatePoker(parseHand(t, tt.worse))
+			if better <= worse {
+				t.Errorf("%s (%v) should beat %s (%v)", tt.better, better, tt.worse, worse)
+			}
+		})
+	}
+}
+
+// Hands that split the pot
+func TestEvaluatePokerTie(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+	}{
+		{"same straight", "9S TD JC QH KS", "9H TC JD QS KH"},
+		{"same wheel", "AS 2D 3C 4H 5S", "AD 2C 3H 4S 5D"},
+		{"same flush ranks", "AH JH 8H 5H 2H", "AS JS 8S 5S 2S"},
+		{"same two pair and kicker", "KS KD 3C 3H 5S", "KC KH 3D 3S 5D"},
+		{"the board plays", "AS KS QS JD 9C 2H 3D", "AS KS QS JD 9C 4H 5D"},
+		{"sixth card doesn't count", "AS AD KC QH JS 3D 2C", "AS AD KC QH JS 4D 3C"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := EvaluatePoker(parseHand(t, tt.a))
+			b := EvaluatePoker(parseHand(t, tt.b))
+			if a != b {
+				t.Errorf("%s (%v) and %s (%v) should tie", tt.a, a, tt.b, b)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+)
+
+// Delivers messages published on a topic to every subscriber of that topic, on every node.
+// Chat and voice send everything through it so lobby-wide messages reach connections
+// wherever they are held. Every node of a cluster must share one
+type PubSub interface {
+	Publish(topic string, data []byte) error
+	// Calls handler with every message published on the topic until unsubscribe is called
+	Subscribe(topic string, handler func(data []byte)) (unsubscribe func())
+}
+
+// A PubSub which only delivers within this process, enough for a single node and for tests.
+// Handlers are called synchronously, so messages are delivered in the order they were published
+type LocalPubSub struct {
+	mu     sync.RWMutex // Protect topics and nextID
+	topics map[string]map[int]func(data []byte)
+	nextID int
+}
+
+func NewLocalPubSub() *LocalPubSub {
+	return &LocalPubSub{
+		topics: make(map[string]map[int]func(data []byte)),
+	}
+}
+
+func (ps *LocalPubSub) Publish(topic string, data []byte) error {
+	ps.mu.RLock()
+	handlers := make([]func(data []byte), 0, len(ps.topics[topic]))
+	for _, h := range ps.topics[topic] {
+		handlers = append(handlers, h)
+	}
+	ps.mu.RUnlock()
+
+	for _, h := range handlers {
+		h(data)
+	}
+
+	return nil
+}
+
+func (ps *LocalPubSub) Subscribe(topic string, handler func(data []byte)) func() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	id := ps.nextID
+	ps.nextID++
+	if ps.topics[topic] == nil {
+		ps.topics[topic] = make(map[int]func(data []byte))
+	}
+	ps.topics[topic][id] = handler
+
+	return func() {
+		ps.mu.Lock()
+		defer ps.mu.Unlock()
+
+		delete(ps.topics[topic], id)
+		if len(ps.topics[topic]) == 0 {
+			delete(ps.topics, topic)
+		}
+	}
+}
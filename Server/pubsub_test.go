@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLocalPubSub(t *testing.T) {
+	ps := NewLocalPubSub()
+
+	var first, second []string
+	unsubscribe := ps.Subscribe("chat.a", func(data []byte) { first = append(first, string(data)) })
+	ps.Subscribe("chat.a", func(data []byte) { second = append(second, string(data)) })
+	ps.Subscribe("chat.b", func(data []byte) { t.Errorf("chat.b got %q", data) })
+
+	ps.Publish("chat.a", []byte("1"))
+	ps.Publish("chat.a", []byte("2"))
+	unsubscribe()
+	ps.Publish("chat.a", []byte("3"))
+
+	if want := []string{"1", "2"}; !reflect.DeepEqual(first, want) {
+		t.Errorf("first subscriber got %v, want %v", first, want)
+	}
+
+	if want := []string{"1", "2", "3"}; !reflect.DeepEqual(second, want) {
+		t.Errorf("second subscriber got %v, want %v", second, want)
+	}
+
+	// Publishing from a handler doesn't deadlock
+	ps.Subscribe("voice.a", func(data []byte) {
+		if string(data) == "ping" {
+			ps.Publish("voice.a", []byte("pong"))
+		}
+	})
+	ps.Publish("voice.a", []byte("ping"))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A token bucket which refills at a constant rate up to a maximum burst size
+type TokenBucket struct {
+	mu     sync.Mutex // Protect tokens and last
+	rate   float64    // Tokens added per second
+	burst  float64    // Maximum number of tokens held at once
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Takes a token from the bucket if one is available
+func (b *TokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens -= 1
+	return true
+}
+
+// Limits how many of something, like connections or lobbies, each IP address holds at once
+type IPLimiter struct {
+	mu     sync.Mutex // Protect counts
+	max    int        // 0 for no limit
+	counts map[string]int
+}
+
+func NewIPLimiter(max int) *IPLimiter {
+	return &IPLimiter{
+		max:    max,
+		counts: make(map[string]int),
+	}
+}
+
+// Counts one more for the IP unless it already holds the maximum
+func (l *IPLimiter) Acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.max > 0 && l.counts[ip] >= l.max {
+		return false
+	}
+
+	l.counts[ip]++
+	return true
+}
+
+func (l *IPLimiter) Release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.counts[ip] <= 1 {
+		delete(l.counts, ip)
+	} else {
+		l.counts[ip]--
+	}
+}
+
+// The IP address a request came from, without the port. Requests routed by another node
+// carry the client's IP in a header
+func remoteIP(r *http.Request) string {
+	if ip := r.Header.Get(headerClusterClientIP); ip != "" && routed(r) {
+		return ip
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -3,8 +3,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"sync"
 
@@ -13,27 +12,43 @@ import (
 	"golang.org/x/exp/slices"
 )
 
+// The maximum size of a single packet read from a game connection. Anything larger closes the connection
+const gameReadLimit = 4096
+
+// Each client may send a burst of moveRateBurst packets, after which they are limited
+// to moveRateLimit packets per second
+const (
+	moveRateLimit = 10
+	moveRateBurst = 20
+)
+
+// Returned when a client sends a move it can't make right now
+var errIllegalMove = errors.New("illegal move sent")
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+	CheckOrigin:     checkOrigin,
 }
 
 type Client struct {
-	Server     *GameServer
+	Server  *GameServer
+	conn    Channel
+	bot     bool
+	ip      string       // Empty for bots
+	limiter *TokenBucket // Limits how quickly packets are handled
+
+	// Written by the client's lobby, but read when the connection closes and while the client
+	// joins or leaves a lobby
+	mu         sync.Mutex // Protect legalMoves, log, closed and _lastSent
 	legalMoves []string
+	log        *slog.Logger // Describes the client as of legalMoves
 	closed     bool
-	connMu     sync.Mutex // Protect conn
-	conn       *websocket.Conn
-	bot        bool
-
-	_lastSent []byte
+	_lastSent  []byte
 }
 
 func (c *Client) send(data []byte) error {
-	c.connMu.Lock()
-	defer c.connMu.Unlock()
-	return c.conn.WriteMessage(websocket.TextMessage, data)
+	return c.conn.Send(data)
 }
 
 func (c *Client) Send(p *Packet) {
@@ -43,33 +58,41 @@ func (c *Client) Send(p *Packet) {
 
 	data, err := json.Marshal(p)
 	if err != nil {
-		log.Println("Unmarsahal:", err)
+		slog.Error("marshal packet", "err", err)
 		return
 	}
 
 	if p.Type == PacketTypeError {
 		if err := c.send(data); err != nil {
-			log.Println("Error sending error packet:", err)
+			slog.Debug("send error packet", "err", err)
 			c.Disconnect()
 		}
 
 		return
 	}
 
+	c.mu.Lock()
 	patch, err := jsonpatch.CreateMergePatch(c._lastSent, data)
 	if err != nil {
-		log.Println("Error creating merge patch:", err)
+		c.mu.Unlock()
+		slog.Error("create merge patch", "err", err)
 		return
 	}
 	// If patch is empty, nothing needs to be sent
 	if len(patch) == 2 {
+		c.mu.Unlock()
 		return
 	}
 
+	metricPatchSize.Observe(float64(len(patch)))
+
 	c._lastSent = data
+	// Sent while locked so patches arrive in the order they were made
+	err = c.send(patch)
+	c.mu.Unlock()
 
-	if err := c.send(patch); err != nil {
-		log.Println("Error sending patch packet:", err)
+	if err != nil {
+		slog.Debug("send patch packet", "err", err)
 		c.Disconnect()
 	}
 }
@@ -79,18 +102,39 @@ func (c *Client) SendError(err error) {
 }
 
 func (c *Client) Disconnect() {
+	c.mu.Lock()
 	// Already closed once
 	if c.closed {
+		c.mu.Unlock()
 		return
 	}
-
 	c.closed = true
+	c.mu.Unlock()
+
 	c.conn.Close()
 	c.Server.game.Disconnect(c)
 }
 
+// Whether the client may make every one of the moves right now
+func (c *Client) legal(moves []string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, m := range moves {
+		if !slices.Contains(c.legalMoves, m) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Sends the client the game from their perspective. Must run on their lobby's goroutine, if they are in one
 func (c *Client) Sync() {
-	if c.closed {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
 		return
 	}
 
@@ -98,7 +142,16 @@ func (c *Client) Sync() {
 	if moves == nil {
 		moves = []string{}
 	}
+
+	log := slog.Default()
+	if lm, ok := c.Server.game.(*LobbyManager); ok {
+		log = lm.logger(c)
+	}
+
+	c.mu.Lock()
 	c.legalMoves = moves
+	c.log = log
+	c.mu.Unlock()
 
 	c.Send(&Packet{
 		Type:  PacketTypeMessage,
@@ -119,31 +172,89 @@ func NewGameServer(game Game) *GameServer {
 	}
 }
 
+func (s *GameServer) newClient(conn Channel, ip string) *Client {
+	return &Client{
+		Server:     s,
+		legalMoves: []string{},
+		conn:       conn,
+		ip:         ip,
+		limiter:    NewTokenBucket(moveRateLimit, moveRateBurst),
+		_lastSent:  []byte("{}"),
+	}
+}
+
+// Counts and logs a packet that was rejected before reaching the game and tells the client why
+func (c *Client) reject(reason string, packet *Packet, err error) {
+	metricRejectedPackets.WithLabelValues(reason).Inc()
+	c.logger().Debug("packet rejected", "reason", reason, "move", packet.Moves)
+	c.SendError(err)
+}
+
+// Handles a single packet sent by the client
+func (c *Client) handleMessage(msg []byte) {
+	packet := &Packet{}
+	err := json.Unmarshal(msg, packet)
+	if err != nil {
+		c.logger().Debug("invalid packet", "err", err)
+		return
+	}
+
+	if !c.limiter.Allow() {
+		c.reject("rate_limited", packet, errors.New("you are sending moves too quickly"))
+		return
+	}
+
+	if packet.Type != PacketTypeMessage {
+		c.reject("unknown_type", packet, errors.New("unknown packet type"))
+		return
+	}
+
+	if len(packet.Moves) < 1 {
+		c.reject("no_moves", packet, errors.New("no moves sent"))
+		return
+	}
+
+	if len(packet.Moves) > 10 {
+		c.reject("too_many_moves", packet, errors.New("too many moves sent"))
+		return
+	}
+
+	var data map[string]interface{}
+	if packet.Data != nil {
+		data = packet.Data
+	}
+
+	// The moves are checked against the legal moves where they execute
+	if err := c.Server.game.ExecuteMoves(c, packet.Moves, data); errors.Is(err, errIllegalMove) {
+		c.reject("illegal_move", packet, err)
+	} else if err != nil {
+		c.SendError(err)
+	}
+}
+
 func (s *GameServer) handleWs(w http.ResponseWriter, r *http.Request) {
-	defer func() {
-		// Just to make sure the server won't crash
-		if r := recover(); r != nil {
-			fmt.Println("recovered error in handleWs")
-			if err, ok := r.(error); ok {
-				fmt.Println(err.Error())
-			} else {
-				fmt.Println(r)
-			}
-		}
-	}()
+	defer logRecover("handleWs")
+
+	ip, ok := acquireConn(w, r)
+	if !ok {
+		return
+	}
+	defer connLimiter.Release(ip)
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Upgrade:", err)
+		slog.Warn("upgrade failed", "socket", "game", "remote", r.RemoteAddr, "err", err)
 		return
 	}
+	conn.SetReadLimit(gameReadLimit)
 
-	c := &Client{
-		Server:     s,
-		legalMoves: []string{},
-		conn:       conn,
-		_lastSent:  []byte("{}"),
-	}
+	keepAlive := startKeepAlive(conn, idleTimeout)
+	defer keepAlive.stop()
+
+	metricConnectedClients.WithLabelValues("game").Inc()
+	defer metricConnectedClients.WithLabelValues("game").Dec()
+
+	c := s.newClient(newWsChannel(conn), ip)
 	c.Sync()
 
 	conn.SetCloseHandler(func(code int, text string) error {
@@ -159,49 +270,8 @@ func (s *GameServer) handleWs(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if messageType == websocket.TextMessage {
-			packet := &Packet{}
-			err := json.Unmarshal(msg, packet)
-			if err != nil {
-				log.Println("Unmarshal:", err)
-				continue
-			}
-
-			if packet.Type != PacketTypeMessage {
-				c.SendError(errors.New("unknown packet type"))
-				continue
-			}
-
-			if len(packet.Moves) < 1 {
-				c.SendError(errors.New("no moves sent"))
-				continue
-			}
-
-			if len(packet.Moves) > 10 {
-				c.SendError(errors.New("too many moves sent"))
-				continue
-			}
-
-			illegalMoveMade := false
-			for _, m := range packet.Moves {
-				if !slices.Contains(c.legalMoves, m) {
-					illegalMoveMade = true
-					break
-				}
-			}
-			if illegalMoveMade {
-				c.SendError(errors.New("illegal move sent"))
-				continue
-			}
-
-			var data map[string]interface{}
-			if packet.Data != nil {
-				data = packet.Data
-			}
-
-			if err := c.Server.game.ExecuteMoves(c, packet.Moves, data); err != nil {
-				c.SendError(err)
-				continue
-			}
+			keepAlive.touch()
+			c.handleMessage(msg)
 		}
 	}
 }
@@ -0,0 +1,154 @@
+package main
+
+import "errors"
+
+// Shared by games where players take turns getting rid of their cards onto a play pile, like
+// Uno and Crazy Eights. Shedding deals, keeps the turn order and its direction, refills the
+// draw pile from the play pile and tracks cards a player is forced to draw. Which cards can be
+// played and what they do is up to each game. Cards are whatever ints the game's deck uses
+
+var errNoCardsLeft = errors.New("all cards have been drawn")
+
+type Shedding struct {
+	CurrentPlayer int      `json:"current_player"` // Whose turn is it
+	PlayPile      *Pile    `json:"play_pile"`      // What cards have been played so far
+	PlayerOrder   []string `json:"player_order"`   // What order do players play in
+	Clockwise     bool     `json:"clockwise"`      // Are we moving clockwise
+	DrawNum       int      `json:"draw_num"`       // The number of cards that are to be drawn by whoever accepts it (default 0)
+	Winners       []string `json:"winners"`        // Who won and in what order
+
+	drawPile *Pile
+	hands    Hands
+}
+
+// Deals handSize cards from the shuffled draw pile to each player. The game then starts the
+// play pile with StartPile
+func NewShedding(players []string, drawPile *Pile, handSize int) *Shedding {
+	s := &Shedding{
+		PlayPile:    &Pile{},
+		PlayerOrder: players,
+		Clockwise:   true,
+		Winners:     []string{},
+		drawPile:    drawPile,
+		hands:       Hands{},
+	}
+
+	for _, id := range players {
+		cards := Pile(drawPile.Draw(handSize))
+		s.hands[id] = &cards
+	}
+
+	return s
+}
+
+// Turns over cards from the draw pile until one that ok accepts, which starts the play pile.
+// The rest go back under the draw pile. If ok accepts none of them, like when the players hold
+// every card it would, the first card starts the pile after all
+func (s *Shedding) StartPile(ok func(card int) bool) {
+	for i := 0; i < len(*s.drawPile); i++ {
+		cards := Pile(s.drawPile.Draw(1))
+		if ok(cards[0]) {
+			s.PlayPile = &cards
+			return
+		}
+
+		s.drawPile.Insert(cards)
+	}
+
+	cards := Pile(s.drawPile.Draw(1))
+	s.PlayPile = &cards
+}
+
+func (s *Shedding) Current() string {
+	return s.PlayerOrder[s.CurrentPlayer]
+}
+
+// The card on top of the play pile
+func (s *Shedding) Top() int {
+	return (*s.PlayPile)[len(*s.PlayPile)-1]
+}
+
+// How many players still have cards
+func (s *Shedding) Playing() int {
+	return len(s.PlayerOrder) - len(s.Winners)
+}
+
+// Makes sure the draw pile has at least n cards. If draw pile could
+// not be refilled, (i.e. players have drawn too many cards), this
+// returns false
+func (s *Shedding) checkDrawPile(n int) bool {
+	if len(*s.drawPile) < n {
+		s.drawPile.Insert(s.PlayPile.Draw(len(*s.PlayPile) - 1))
+		s.drawPile.Shuffle()
+	}
+
+	return len(*s.drawPile) >= n
+}
+
+// Whether there is a card left to draw, counting the play pile under its top card
+func (s *Shedding) CanDraw() bool {
+	return len(*s.drawPile)+len(*s.PlayPile) > 1
+}
+
+// Gives the player n cards, or as many as are left
+func (s *Shedding) Give(player string, n int) error {
+	enough := s.checkDrawPile(n)
+	s.hands[player].Insert(s.drawPile.Draw(n))
+
+	if !enough {
+		return errNoCardsLeft
+	}
+
+	return nil
+}
+
+// The draw move. If cards were stacked on the player they draw all of them and lose their turn,
+// otherwise they draw one and keep it
+func (s *Shedding) Draw(player string) error {
+	num := 1
+	if s.DrawNum > 0 {
+		num = s.DrawNum
+		s.NextPlayer()
+		s.DrawNum = 0
+	}
+
+	return s.Give(player, num)
+}
+
+// Moves the card from the player's hand onto the play pile. Players who run out of cards are
+// added to the winners. The game applies the card and moves to the next player itself
+func (s *Shedding) Discard(player string, card int) {
+	s.PlayPile.Insert([]int{card})
+	s.hands[player].Remove(Card(card))
+
+	if len(*s.hands[player]) == 0 {
+		s.Winners = append(s.Winners, player)
+	}
+}
+
+// Changes direction. With two players left this skips the other player instead
+func (s *Shedding) Reverse() {
+	s.Clockwise = !s.Clockwise
+	if s.Playing() == 2 {
+		s.NextPlayer()
+	}
+}
+
+func (s *Shedding) NextPlayer() {
+	cur := s.CurrentPlayer
+	for {
+		if s.Clockwise {
+			s.CurrentPlayer = (s.CurrentPlayer + 1) % len(s.PlayerOrder)
+		} else {
+			s.CurrentPlayer -= 1
+			// Euclidean modulus
+			if s.CurrentPlayer < 0 {
+				s.CurrentPlayer += len(s.PlayerOrder)
+			}
+		}
+
+		if s.CurrentPlayer == cur || len(*s.hands[s.PlayerOrder[s.CurrentPlayer]]) > 0 {
+			break
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Stops new clients from joining lobbies
+func (lm *LobbyManager) Drain() {
+	atomic.StoreInt32(&lm.draining, 1)
+}
+
+func (lm *LobbyManager) Draining() bool {
+	return atomic.LoadInt32(&lm.draining) == 1
+}
+
+// Sends an error to every client in every lobby
+func (lm *LobbyManager) Broadcast(err error) {
+	lm.Lobbies.Range(func(_, entry interface{}) bool {
+		lobby := entry.(*Lobby)
+		lobby.post(func() {
+			for _, c := range lobby.Clients {
+				if !c.Disconnected {
+					c.SendError(err)
+				}
+			}
+		})
+
+		return true
+	})
+}
+
+// Tells every player the server is going down, repeating the message as the deadline approaches
+func (lm *LobbyManager) Countdown(grace time.Duration) {
+	deadline := time.Now().Add(grace)
+	announce := func(remaining time.Duration) {
+		lm.Broadcast(fmt.Errorf("the server is shutting down for maintenance in %d seconds", int(remaining.Round(time.Second).Seconds())))
+	}
+
+	announce(grace)
+	for _, mark := range []time.Duration{30 * time.Second, 10 * time.Second, 5 * time.Second, 3 * time.Second, 2 * time.Second, time.Second} {
+		if mark >= grace {
+			continue
+		}
+
+		time.Sleep(time.Until(deadline.Add(-mark)))
+		announce(mark)
+	}
+
+	time.Sleep(time.Until(deadline))
+}
+
+// Waits for moves that are executing to finish and stops any more from running, including bots'
+func (lm *LobbyManager) StopMoves() {
+	lm.moves.Lock()
+}
+
+type LobbySnapshot struct {
+	Lobby  *Lobby                 `json:"lobby"`
+	Game   string                 `json:"game,omitempty"`
+	States map[string]interface{} `json:"states,omitempty"` // The game from the perspective of each client
+	At     int64                  `json:"at"`
+}
+
+// Must run on the lobby's goroutine
+func (l *Lobby) Snapshot() *LobbySnapshot {
+	s := &LobbySnapshot{
+		Lobby: l,
+		At:    time.Now().UnixMilli(),
+	}
+
+	if l.game != nil {
+		s.States = make(map[string]interface{})
+		for id, c := range l.Clients {
+			s.Game = l.game.Name(c.Client)
+			s.States[id] = l.game.State(c.Client)
+		}
+	}
+
+	return s
+}
+
+// Writes a JSON snapshot of every lobby into dir
+func (lm *LobbyManager) WriteSnapshots(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var err error
+	lm.Lobbies.Range(func(_, entry interface{}) bool {
+		lobby := entry.(*Lobby)
+		var data []byte
+		e := lobby.call(func() (err error) {
+			data, err = json.MarshalIndent(lobby.Snapshot(), "", "\t")
+			return err
+		})
+		// Deleted since the range started
+		if e == errLobbyGone {
+			return true
+		}
+		if e != nil {
+			err = e
+			return false
+		}
+
+		// Lobby IDs are chosen by players, so they must be escaped
+		path := filepath.Join(dir, "lobby-"+url.PathEscape(lobby.ID)+".json")
+		if e := os.WriteFile(path, data, 0644); e != nil {
+			err = e
+			return false
+		}
+
+		slog.Info("wrote snapshot", "lobby", lobby.ID, "path", path)
+		return true
+	})
+
+	return err
+}
+
+// Closes every chat and voice connection
+func (lm *LobbyManager) closeAllChatAndVoice() {
+	lm.Lobbies.Range(func(_, entry interface{}) bool {
+		lobby := entry.(*Lobby)
+		lobby.chat.close()
+		lobby.voice.close()
+		return true
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often the certificate and key files are checked for changes
+const certReloadInterval = time.Minute
+
+// Serves the certificate from the given files, reloading it whenever they change so
+// renewed certificates are picked up without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex // Protect cert and modTime
+	cert    *tls.Certificate
+	modTime time.Time // The latest modification time of either file when cert was loaded
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	go c.watch()
+	return c, nil
+}
+
+func (c *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+func (c *certReloader) load() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.mu.Unlock()
+	return nil
+}
+
+// Reloads the certificate whenever the files change. The old certificate is kept if the new one is invalid,
+// e.g. if only one of the files has been replaced so far
+func (c *certReloader) watch() {
+	for range time.Tick(certReloadInterval) {
+		modTime, err := c.latestModTime()
+		if err != nil {
+			slog.Error("stat certificate", "err", err)
+			continue
+		}
+
+		c.mu.RLock()
+		changed := modTime.After(c.modTime)
+		c.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := c.load(); err != nil {
+			slog.Error("reload certificate", "err", err)
+			continue
+		}
+
+		slog.Info("reloaded certificate", "cert_file", c.certFile)
+	}
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// Redirects every request to the same URL over HTTPS on the port of httpsAddr
+func redirectToHTTPS(httpsAddr string) http.HandlerFunc {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Sockets are pinged every pingInterval and closed if no pong arrives within pongWait
+const (
+	pingInterval = 30 * time.Second
+	pongWait     = 60 * time.Second
+)
+
+// Messages are sent from the lobby's goroutine, so a client who stops reading may only hold
+// it up for writeWait. The socket is closed once a write fails
+const writeWait = 5 * time.Second
+
+// Limits the number of open sockets per IP. Set from the config
+var connLimiter = NewIPLimiter(0)
+
+// How long game sockets may go without a message before they are closed, 0 for never. Set from the config
+var idleTimeout time.Duration
+
+// A connection to a single client over which one subsystem (game, chat or voice) sends
+// its messages. It is either a dedicated websocket or a channel of a multiplexed one
+type Channel interface {
+	Send(data []byte) error
+	Close() error
+}
+
+// A channel backed by its own websocket connection
+type wsChannel struct {
+	mu   sync.Mutex // Protect conn writes
+	conn *websocket.Conn
+}
+
+func newWsChannel(conn *websocket.Conn) *wsChannel {
+	return &wsChannel{conn: conn}
+}
+
+func (c *wsChannel) Send(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeMessage(c.conn, data)
+}
+
+// Writes a text message, closing the connection if it can't be written within writeWait.
+// Must not be called concurrently for the same connection
+func writeMessage(conn *websocket.Conn, data []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	err := conn.WriteMessage(websocket.TextMessage, data)
+	if err != nil {
+		conn.Close()
+	}
+
+	return err
+}
+
+// Sends a close frame before closing the connection so the client knows it was intentional
+func (c *wsChannel) Close() error {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	return c.conn.Close()
+}
+
+// Counts the connection against the request's IP. If the IP has too many connections already,
+// the request is rejected and false is returned
+func acquireConn(w http.ResponseWriter, r *http.Request) (ip string, ok bool) {
+	ip = remoteIP(r)
+	if !connLimiter.Acquire(ip) {
+		slog.Info("too many connections", "remote", r.RemoteAddr)
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return ip, false
+	}
+
+	return ip, true
+}
+
+// Pings a websocket to detect dead connections and closes it once it has been idle for too long
+type keepAlive struct {
+	conn *websocket.Conn
+	idle time.Duration // 0 for no limit
+	last int64         // When touch was last called
+	done chan struct{}
+	once sync.Once
+}
+
+// Starts pinging the connection. Must be called before the first read
+func startKeepAlive(conn *websocket.Conn, idle time.Duration) *keepAlive {
+	k := &keepAlive{
+		conn: conn,
+		idle: idle,
+		last: time.Now().UnixMilli(),
+		done: make(chan struct{}),
+	}
+
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	go k.run()
+	return k
+}
+
+func (k *keepAlive) run() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-ticker.C:
+		}
+
+		if k.idle > 0 && time.Since(time.UnixMilli(atomic.LoadInt64(&k.last))) > k.idle {
+			k.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "idle for too long"), time.Now().Add(time.Second))
+			k.conn.Close()
+			return
+		}
+
+		// WriteControl is safe to call alongside the connection's other writes
+		if err := k.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+			return
+		}
+	}
+}
+
+// Marks the connection as active
+func (k *keepAlive) touch() {
+	atomic.StoreInt64(&k.last, time.Now().UnixMilli())
+}
+
+func (k *keepAlive) stop() {
+	k.once.Do(func() { close(k.done) })
+}
+
+// Logs and swallows any panic in a connection handler. Must be deferred directly
+func logRecover(handler string) {
+	// Just to make sure the server won't crash
+	if r := recover(); r != nil {
+		metricRecoveredPanics.WithLabelValues(handler).Inc()
+		slog.Error("recovered panic", "handler", handler, "panic", r, "stack", string(debug.Stack()))
+	}
+}
+
+// Origins allowed to open sockets. Every origin is allowed if empty. Set from the config
+var allowedOrigins []string
+
+// The CheckOrigin function shared by every upgrader
+func checkOrigin(r *http.Request) bool {
+	if len(allowedOrigins) == 0 {
+		return true
+	}
+
+	origin := r.Header.Get("Origin")
+	// Requests without an origin don't come from browsers
+	if origin == "" {
+		return true
+	}
+
+	for _, a := range allowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return true
+		}
+	}
+
+	slog.Info("origin not allowed", "origin", origin, "remote", r.RemoteAddr)
+	return false
+}
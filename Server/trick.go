@@ -0,0 +1,435 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// A framework for trick-taking games like Hearts, Spades, Euchre and Whist. TrickGame deals,
+// rotates the lead, enforces following suit, resolves tricks with an optional trump and keeps
+// scores across rounds. Each game embeds it and implements TrickRules for everything else.
+// Cards are played with their short codes as moves, e.g. "QH"
+
+type TrickPhase string
+
+// Games add their own phases, like passing or bidding, before TrickPhasePlay
+const (
+	TrickPhasePlay TrickPhase = "play"
+	TrickPhaseDone TrickPhase = "done" // The game is over
+)
+
+type TrickRules interface {
+	// Builds the deck dealt evenly between the players at the start of each round
+	Deck() *Pile
+	// The trump suit this round, NoSuit for none
+	Trump() Suit
+	// Who leads the first trick of the round
+	FirstLeader() string
+	// Whether the player may play the card besides following suit, e.g. whether hearts are broken.
+	// If none of the cards they could play are allowed, they may play any of them
+	Allowed(player string, c Card) bool
+	// Called with every finished trick
+	TrickDone(trick *Trick)
+	// Points for each player once every card of the round has been played
+	ScoreRound() map[string]int
+	// Whether the game is over after a round has been scored
+	GameOver() bool
+}
+
+// Implemented by rules that need to do something once the cards are dealt, like starting
+// a passing or bidding phase
+type TrickRoundStarter interface {
+	TrickRules
+	RoundStarted()
+}
+
+// Implemented by rules where cards don't simply rank within their suit, like the bowers in Euchre
+type TrickCardOrder interface {
+	TrickRules
+	// The suit the card belongs to when following
+	SuitOf(c Card) Suit
+	// Whether a beats b, given the suit that was led
+	Beats(a Card, b Card, led Suit) bool
+}
+
+type TrickPlay struct {
+	Player string `json:"player"`
+	Card   Card   `json:"card"`
+}
+
+type Trick struct {
+	Plays  []TrickPlay `json:"plays"`
+	Led    Suit        `json:"led"`
+	Winner string      `json:"winner,omitempty"`
+}
+
+type TrickGame struct {
+	Phase         TrickPhase       `json:"phase"`
+	PlayerOrder   []string         `json:"player_order"`   // Seating, clockwise
+	Dealer        int              `json:"dealer"`         // Index in PlayerOrder
+	CurrentPlayer int              `json:"current_player"` // Index in PlayerOrder
+	Round         int              `json:"round"`
+	TrumpSuit     Suit             `json:"trump"` // NoSuit if there is none
+	Trick         *Trick           `json:"trick"` // The trick being played
+	Tricks        []*Trick         `json:"tricks"`
+	TricksWon     map[string]int   `json:"tricks_won"`
+	Scores        map[string]int   `json:"scores"`
+	RoundScores   []map[string]int `json:"round_scores"` // The points of every round so far
+	Winners       []string         `json:"winners"`      // Everyone from best to worst once the game is over
+
+	lobby   *Lobby
+	rules   TrickRules
+	lowWins bool // Whether the lowest score wins, like in Hearts
+	hands   Hands
+}
+
+type TrickState struct {
+	TrickGame
+	Hand       Cards          `json:"hand"`
+	OtherHands map[string]int `json:"other_hands"`
+	Lobby      *LobbyState    `json:"lobby"`
+}
+
+// Seats the lobby's clients in the order they joined. The first round is dealt by StartRound,
+// which games call once their own state is set up
+func NewTrickGame(l *Lobby, rules TrickRules, lowWins bool) *TrickGame {
+	t := &TrickGame{
+		TricksWon:   make(map[string]int),
+		Scores:      make(map[string]int),
+		RoundScores: []map[string]int{},
+		Winners:     []string{},
+		lobby:       l,
+		rules:       rules,
+		lowWins:     lowWins,
+	}
+
+	for id := range l.Clients {
+		t.PlayerOrder = append(t.PlayerOrder, id)
+		t.Scores[id] = 0
+	}
+	sort.Slice(t.PlayerOrder, func(i, j int) bool {
+		return l.Clients[t.PlayerOrder[i]].JoinedAt < l.Clients[t.PlayerOrder[j]].JoinedAt
+	})
+
+	// The dealer moves left every round, so the first round is dealt by whoever joined first
+	t.Dealer = len(t.PlayerOrder) - 1
+	return t
+}
+
+// Deals a new round and starts playing, unless the rules start another phase first
+func (t *TrickGame) StartRound() {
+	t.Round++
+	t.Dealer = (t.Dealer + 1) % len(t.PlayerOrder)
+	t.Trick = nil
+	t.Tricks = []*Trick{}
+
+	t.hands = Hands{}
+	for _, id := range t.PlayerOrder {
+		t.hands[id] = &Pile{}
+		t.TricksWon[id] = 0
+	}
+
+	deck := t.rules.Deck()
+	deck.Shuffle()
+	deck.Distribute(t.hands)
+
+	t.TrumpSuit = t.rules.Trump()
+	t.Phase = TrickPhasePlay
+	t.CurrentPlayer = t.seat(t.rules.FirstLeader())
+
+	if starter, ok := t.rules.(TrickRoundStarter); ok {
+		starter.RoundStarted()
+	}
+}
+
+// The index of the player in PlayerOrder
+func (t *TrickGame) seat(player string) int {
+	for i, id := range t.PlayerOrder {
+		if id == player {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// The player offset seats to the left of the given one
+func (t *TrickGame) LeftOf(player string, offset int) string {
+	n := len(t.PlayerOrder)
+	return t.PlayerOrder[((t.seat(player)+offset)%n+n)%n]
+}
+
+// The player to the dealer's left, who leads first in most games
+func (t *TrickGame) Eldest() string {
+	return t.PlayerOrder[(t.Dealer+1)%len(t.PlayerOrder)]
+}
+
+func (t *TrickGame) Current() string {
+	return t.PlayerOrder[t.CurrentPlayer]
+}
+
+func (t *TrickGame) Hand(player string) Cards {
+	return t.hands[player].Cards()
+}
+
+// Who holds the card
+func (t *TrickGame) Holder(c Card) string {
+	for id, hand := range t.hands {
+		if hand.Cards().Contains(c) {
+			return id
+		}
+	}
+
+	return ""
+}
+
+// Whether no trick of the round has finished yet
+func (t *TrickGame) FirstTrick() bool {
+	return len(t.Tricks) == 0
+}
+
+// Whether the next card played leads a new trick
+func (t *TrickGame) Leading() bool {
+	return t.Trick == nil || t.Trick.Winner != ""
+}
+
+// Whether the card has been played this round
+func (t *TrickGame) Played(c Card) bool {
+	tricks := t.Tricks
+	if !t.Leading() {
+		tricks = append([]*Trick{t.Trick}, tricks...)
+	}
+
+	for _, trick := range tricks {
+		for _, p := range trick.Plays {
+			if p.Card == c {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (t *TrickGame) suitOf(c Card) Suit {
+	if order, ok := t.rules.(TrickCardOrder); ok {
+		return order.SuitOf(c)
+	}
+
+	return c.Suit()
+}
+
+// Whether a beats b in a trick where led was led
+func (t *TrickGame) beats(a Card, b Card, led Suit) bool {
+	if order, ok := t.rules.(TrickCardOrder); ok {
+		return order.Beats(a, b, led)
+	}
+
+	aTrump := t.TrumpSuit != NoSuit && a.Suit() == t.TrumpSuit
+	bTrump := t.TrumpSuit != NoSuit && b.Suit() == t.TrumpSuit
+	if aTrump != bTrump {
+		return aTrump
+	}
+
+	// b either follows or is trump, so an off-suit card never beats it
+	if a.Suit() != b.Suit() {
+		return false
+	}
+
+	return a.Rank() > b.Rank()
+}
+
+// The play winning the current trick so far
+func (t *TrickGame) winning() TrickPlay {
+	winner := t.Trick.Plays[0]
+	for _, p := range t.Trick.Plays[1:] {
+		if t.beats(p.Card, winner.Card, t.Trick.Led) {
+			winner = p
+		}
+	}
+
+	return winner
+}
+
+// The cards the player may play right now
+func (t *TrickGame) Playable(player string) Cards {
+	hand := t.Hand(player)
+	candidates := hand
+	if !t.Leading() {
+		if following := t.following(hand); len(following) > 0 {
+			candidates = following
+		}
+	}
+
+	allowed := Cards{}
+	for _, c := range candidates {
+		if t.rules.Allowed(player, c) {
+			allowed = append(allowed, c)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return candidates
+	}
+
+	return allowed
+}
+
+// The cards in the hand that follow the suit that was led
+func (t *TrickGame) following(hand Cards) Cards {
+	following := Cards{}
+	for _, c := range hand {
+		if t.suitOf(c) == t.Trick.Led {
+			following = append(following, c)
+		}
+	}
+
+	return following
+}
+
+// Plays the card for the current player, resolving the trick and the round when they finish
+func (t *TrickGame) Play(player string, c Card) error {
+	if t.Phase != TrickPhasePlay || t.Current() != player {
+		return errors.New("it is not your turn")
+	}
+
+	if !t.Playable(player).Contains(c) {
+		return errors.New("you cannot play that card")
+	}
+
+	t.hands[player].Remove(c)
+	if t.Leading() {
+		t.Trick = &Trick{Plays: []TrickPlay{}, Led: t.suitOf(c)}
+	}
+	t.Trick.Plays = append(t.Trick.Plays, TrickPlay{Player: player, Card: c})
+
+	if len(t.Trick.Plays) < len(t.PlayerOrder) {
+		t.CurrentPlayer = (t.CurrentPlayer + 1) % len(t.PlayerOrder)
+		return nil
+	}
+
+	winner := t.winning()
+	t.Trick.Winner = winner.Player
+	t.TricksWon[winner.Player]++
+	t.Tricks = append(t.Tricks, t.Trick)
+	t.rules.TrickDone(t.Trick)
+	// The finished trick stays on the table until the next card is led
+	t.CurrentPlayer = t.seat(winner.Player)
+
+	if len(*t.hands[player]) == 0 {
+		t.endRound()
+	}
+
+	return nil
+}
+
+func (t *TrickGame) endRound() {
+	points := t.rules.ScoreRound()
+	for id, p := range points {
+		t.Scores[id] += p
+	}
+	t.RoundScores = append(t.RoundScores, points)
+
+	if !t.rules.GameOver() {
+		t.StartRound()
+		return
+	}
+
+	t.Phase = TrickPhaseDone
+	t.Winners = append([]string{}, t.PlayerOrder...)
+	sort.SliceStable(t.Winners, func(i, j int) bool {
+		if t.lowWins {
+			return t.Scores[t.Winners[i]] < t.Scores[t.Winners[j]]
+		}
+
+		return t.Scores[t.Winners[i]] > t.Scores[t.Winners[j]]
+	})
+}
+
+// The highest or lowest score so far
+func (t *TrickGame) BestScore() int {
+	best := t.Scores[t.PlayerOrder[0]]
+	for _, s := range t.Scores {
+		if (t.lowWins && s < best) || (!t.lowWins && s > best) {
+			best = s
+		}
+	}
+
+	return best
+}
+
+// LegalMoves implements FreezableGame for the play phase. Games handle their own phases first
+func (t *TrickGame) LegalMoves(client *Client) (moves []string, _ map[string]interface{}) {
+	if t.Phase == TrickPhaseDone {
+		return []string{MoveReturn}, nil
+	}
+
+	id := t.lobby.Client(client).ID
+	if t.Phase != TrickPhasePlay || t.Current() != id {
+		return
+	}
+
+	for _, c := range t.Playable(id) {
+		moves = append(moves, c.String())
+	}
+
+	return
+}
+
+// ExecuteMoves implements FreezableGame for the play phase. Games handle their own phases first
+func (t *TrickGame) ExecuteMoves(client *Client, moves []string, data interface{}) error {
+	c, err := ParseCardCode(moves[0])
+	if err != nil {
+		return err
+	}
+
+	return t.Play(t.lobby.Client(client).ID, c)
+}
+
+// The state shared by every trick-taking game, from the perspective of the client
+func (t *TrickGame) TrickState(client *Client) TrickState {
+	id := t.lobby.Client(client).ID
+	hand := t.Hand(id)
+	hand.Sort()
+
+	return TrickState{
+		TrickGame:  *t,
+		Hand:       hand,
+		OtherHands: t.hands.StateHidden(id),
+		Lobby:      t.lobby.State(client),
+	}
+}
+
+// Inspect implements InspectableGame
+func (t *TrickGame) Inspect() interface{} {
+	return struct {
+		*TrickGame
+		Hands map[string]Cards `json:"hands"`
+	}{t, t.hands.Cards()}
+}
+
+// Picks a playable card for a bot: the highest one that won't win the trick if there is
+// one, otherwise the lowest. Games with smarter bots choose for themselves
+func (t *TrickGame) LowestLoser(player string) Card {
+	playable := t.Playable(player)
+	playable.SortByRank()
+
+	if !t.Leading() {
+		for _, c := range playable {
+			if !t.wouldWin(c) {
+				return c
+			}
+		}
+	}
+
+	return playable[len(playable)-1]
+}
+
+// Whether the card would currently win the trick
+func (t *TrickGame) wouldWin(c Card) bool {
+	if t.Leading() {
+		return true
+	}
+
+	return t.beats(c, t.winning().Card, t.Trick.Led)
+}
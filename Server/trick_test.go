@@ -0,0 +1,320 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Rules with nothing but what the tests set. Every round scores points and the game is over
+// after rounds rounds
+type stubTrickRules struct {
+	game     *TrickGame
+	trump    Suit
+	banned   Cards // Not allowed unless the player has nothing else
+	points   map[string]int
+	rounds   int
+	finished []*Trick
+}
+
+func (r *stubTrickRules) Deck() *Pile {
+	return NewDeck(1, false)
+}
+
+func (r *stubTrickRules) Trump() Suit {
+	return r.trump
+}
+
+func (r *stubTrickRules) FirstLeader() string {
+	return r.game.Eldest()
+}
+
+func (r *stubTrickRules) Allowed(player string, c Card) bool {
+	return !r.banned.Contains(c)
+}
+
+func (r *stubTrickRules) TrickDone(trick *Trick) {
+	r.finished = append(r.finished, trick)
+}
+
+func (r *stubTrickRules) ScoreRound() map[string]int {
+	return r.points
+}
+
+func (r *stubTrickRules) GameOver() bool {
+	return r.game.Round >= r.rounds
+}
+
+// Euchre's bowers with hearts as trump: the jack of diamonds is a heart and beats every
+// other card, followed by the jack of hearts
+type bowerTrickRules struct {
+	*stubTrickRules
+}
+
+func (r *bowerTrickRules) SuitOf(c Card) Suit {
+	if c == NewCard(Jack, Diamonds) {
+		return Hearts
+	}
+
+	return c.Suit()
+}
+
+func (r *bowerTrickRules) power(c Card, led Suit) int {
+	switch {
+	case c == NewCard(Jack, Diamonds):
+		return 200
+	case c == NewCard(Jack, Hearts):
+		return 199
+	case r.SuitOf(c) == Hearts:
+		return 100 + int(c.Rank())
+	case r.SuitOf(c) == led:
+		return int(c.Rank())
+	}
+
+	return 0
+}
+
+func (r *bowerTrickRules) Beats(a Card, b Card, led Suit) bool {
+	return r.power(a, led) > r.power(b, led)
+}
+
+// Seats d, then a to c, and deals the round. d joined first so they deal, and a leads.
+// Hands are replaced by the given ones, which are space separated card codes
+func newTestTrickGame(t *testing.T, rules *stubTrickRules, order TrickRules, lowWins bool, hands map[string]string) *TrickGame {
+	t.Helper()
+
+	l := newLobby("trick", "", nil, NewLocalPubSub())
+	for i, id := range []string{"d", "a", "b", "c"} {
+		l.Clients[id] = &LobbyClient{ID: id, JoinedAt: int64(i)}
+	}
+
+	if order == nil {
+		order = rules
+	}
+
+	game := NewTrickGame(l, order, lowWins)
+	rules.game = game
+	game.StartRound()
+	setTrickHands(t, game, hands)
+	return game
+}
+
+func setTrickHands(t *testing.T, game *TrickGame, hands map[string]string) {
+	t.Helper()
+
+	game.hands = Hands{}
+	for id, codes := range hands {
+		game.hands[id] = parseHand(t, codes).Pile()
+	}
+}
+
+// Plays the cards in turn, failing the test on the first one that is refused
+func playTrick(t *testing.T, game *TrickGame, codes string) {
+	t.Helper()
+
+	for _, c := range parseHand(t, codes) {
+		if err := game.Play(game.Current(), c); err != nil {
+			t.Fatalf("%s playing %v: %v", game.Current(), c, err)
+		}
+	}
+}
+
+func TestTrickFollowSuit(t *testing.T) {
+	game := newTestTrickGame(t, &stubTrickRules{trump: NoSuit, rounds: 1}, nil, false, map[string]string{
+		"a": "2H 9C",
+		"b": "5H KS",
+		"c": "AS QS",
+		"d": "3D 4D",
+	})
+
+	if got := game.Playable("a"); !reflect.DeepEqual(got, parseHand(t, "2H 9C")) {
+		t.Errorf("leader may play %v, want their whole hand", got)
+	}
+
+	playTrick(t, game, "2H")
+
+	if got := game.Playable("b"); !reflect.DeepEqual(got, parseHand(t, "5H")) {
+		t.Errorf("b may play %v, want only their heart", got)
+	}
+
+	if err := game.Play("b", NewCard(King, Spades)); err == nil {
+		t.Error("b played a spade while holding a heart")
+	}
+
+	if err := game.Play("c", NewCard(Ace, Spades)); err == nil {
+		t.Error("c played out of turn")
+	}
+
+	playTrick(t, game, "5H")
+
+	// Without a heart anything goes
+	if got := game.Playable("c"); !reflect.DeepEqual(got, parseHand(t, "AS QS")) {
+		t.Errorf("c may play %v, want their whole hand", got)
+	}
+}
+
+func TestTrickAllowedFallback(t *testing.T) {
+	tests := []struct {
+		name   string
+		hand   string
+		banned string
+		led    string // Played by a before b, if anyone leads
+		want   string
+	}{
+		{"banned cards are left out", "2H 3C", "2H", "", "3C"},
+		{"only banned cards left", "2H 5H", "2H 5H", "", "2H 5H"},
+		{"banned cards don't excuse following", "4C 5H", "4C", "9C", "4C"},
+		{"banned cards left out of following", "4C 6C 5H", "4C", "9C", "6C"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := &stubTrickRules{trump: NoSuit, rounds: 1}
+			if tt.banned != "" {
+				rules.banned = parseHand(t, tt.banned)
+			}
+
+			hands := map[string]string{"a": "9C 8C", "b": tt.hand, "c": "2D 3D", "d": "4D 5D"}
+			game := newTestTrickGame(t, rules, nil, false, hands)
+			if tt.led == "" {
+				// Let b lead
+				game.CurrentPlayer = game.seat("b")
+			} else {
+				playTrick(t, game, tt.led)
+			}
+
+			if got := game.Playable("b"); !reflect.DeepEqual(got, parseHand(t, tt.want)) {
+				t.Errorf("b may play %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrickWinner(t *testing.T) {
+	tests := []struct {
+		name  string
+		trump Suit
+		plays string // By a, b, c and d
+		want  string
+	}{
+		{"highest of the suit led", NoSuit, "5H 9H 2H 7H", "b"},
+		{"off suit cards never win", NoSuit, "5H AS KC 2H", "a"},
+		{"trump suit without trump", Spades, "5H 9H 2H 7H", "b"},
+		{"a trump wins", Spades, "5H 9H 2S AH", "c"},
+		{"highest trump wins", Spades, "5H 3S 7S AH", "c"},
+		{"trump led", Spades, "4S AH KS 3S", "c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plays := parseHand(t, tt.plays)
+			hands := map[string]string{}
+			// Everyone holds a second card so the round doesn't end
+			for i, id := range []string{"a", "b", "c", "d"} {
+				hands[id] = plays[i].String() + " " + NewCard(Two+Rank(i), Diamonds).String()
+			}
+
+			rules := &stubTrickRules{trump: tt.trump, rounds: 1}
+			game := newTestTrickGame(t, rules, nil, false, hands)
+			playTrick(t, game, tt.plays)
+
+			if len(rules.finished) != 1 || rules.finished[0].Winner != tt.want {
+				t.Fatalf("finished tricks %v, want one won by %s", rules.finished, tt.want)
+			}
+
+			if game.TricksWon[tt.want] != 1 {
+				t.Errorf("%s won %d tricks, want 1", tt.want, game.TricksWon[tt.want])
+			}
+
+			// The winner leads the next trick
+			if game.Current() != tt.want || !game.Leading() {
+				t.Errorf("%s is next, want %s to lead", game.Current(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTrickCardOrder(t *testing.T) {
+	rules := &stubTrickRules{trump: Hearts, rounds: 1}
+	game := newTestTrickGame(t, rules, &bowerTrickRules{rules}, false, map[string]string{
+		"a": "AH 2C",
+		"b": "JD 3C",
+		"c": "JH 4C",
+		"d": "KD 5C",
+	})
+
+	// The jack of diamonds is a heart, so b must follow with it
+	playTrick(t, game, "AH")
+	if got := game.Playable("b"); !reflect.DeepEqual(got, parseHand(t, "JD")) {
+		t.Errorf("b may play %v, want JD", got)
+	}
+
+	// The king of diamonds stays a diamond, so d has no heart and may play anything
+	playTrick(t, game, "JD JH")
+	if got := game.Playable("d"); !reflect.DeepEqual(got, parseHand(t, "KD 5C")) {
+		t.Errorf("d may play %v, want their whole hand", got)
+	}
+
+	playTrick(t, game, "KD")
+	if winner := rules.finished[0].Winner; winner != "b" {
+		t.Errorf("%s won the trick, want b with the jack of diamonds", winner)
+	}
+}
+
+func TestTrickRounds(t *testing.T) {
+	rules := &stubTrickRules{trump: NoSuit, rounds: 2, points: map[string]int{"a": 1, "b": 2, "c": 3, "d": 4}}
+	game := newTestTrickGame(t, rules, nil, false, map[string]string{
+		"a": "2C 3C",
+		"b": "4C 2H",
+		"c": "5C 3H",
+		"d": "6C 4H",
+	})
+
+	// d wins the first trick and leads the second
+	playTrick(t, game, "2C 4C 5C 6C")
+	if game.Current() != "d" {
+		t.Fatalf("%s leads the second trick, want d", game.Current())
+	}
+
+	playTrick(t, game, "4H 3C 2H 3H")
+	if len(rules.finished) != 2 || rules.finished[1].Winner != "d" {
+		t.Fatalf("second trick won by %s, want d", rules.finished[1].Winner)
+	}
+
+	// The round was scored and the next one dealt by the next dealer, whose left leads
+	if dealer := game.PlayerOrder[game.Dealer]; game.Round != 2 || dealer != "a" || game.Current() != "b" {
+		t.Errorf("round %d dealt by %s with %s leading, want round 2 dealt by a with b leading", game.Round, dealer, game.Current())
+	}
+
+	if !reflect.DeepEqual(game.RoundScores, []map[string]int{rules.points}) || game.Scores["d"] != 4 {
+		t.Errorf("round scores %v and scores %v", game.RoundScores, game.Scores)
+	}
+
+	if game.TricksWon["d"] != 0 || len(game.Tricks) != 0 {
+		t.Errorf("tricks won %v and tricks %v weren't reset", game.TricksWon, game.Tricks)
+	}
+}
+
+func TestTrickWinners(t *testing.T) {
+	for _, lowWins := range []bool{false, true} {
+		rules := &stubTrickRules{trump: NoSuit, rounds: 1, points: map[string]int{"a": 7, "b": 2, "c": 9, "d": 4}}
+		game := newTestTrickGame(t, rules, nil, lowWins, map[string]string{"a": "2C", "b": "3C", "c": "4C", "d": "5C"})
+		playTrick(t, game, "2C 3C 4C 5C")
+
+		if game.Phase != TrickPhaseDone {
+			t.Fatalf("phase %s after the last round, want done", game.Phase)
+		}
+
+		want, best := []string{"c", "a", "d", "b"}, 9
+		if lowWins {
+			want, best = []string{"b", "d", "a", "c"}, 2
+		}
+
+		if !reflect.DeepEqual(game.Winners, want) {
+			t.Errorf("low wins %v: winners %v, want %v", lowWins, game.Winners, want)
+		}
+
+		if game.BestScore() != best {
+			t.Errorf("low wins %v: best score %d, want %d", lowWins, game.BestScore(), best)
+		}
+	}
+}
@@ -1,142 +1,510 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 	"sync"
+	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
 )
 
+// The maximum size of a single signaling frame. SDP offers are usually a few KB
+const voiceReadLimit = 16 * 1024
+
+type VoiceMessageType string
+
+const (
+	// Client->Server and Server->Client
+	VoiceMessageOffer     VoiceMessageType = "offer"
+	VoiceMessageAnswer    VoiceMessageType = "answer"
+	VoiceMessageCandidate VoiceMessageType = "candidate"
+	VoiceMessageLeave     VoiceMessageType = "leave"
+	VoiceMessageStatus    VoiceMessageType = "status"
+	// Server->Client only
+	VoiceMessagePeers VoiceMessageType = "peers"
+	VoiceMessageJoin  VoiceMessageType = "join"
+	VoiceMessageError VoiceMessageType = "error"
+)
+
+type VoiceMessage struct {
+	Type    VoiceMessageType `json:"type"`
+	From    string           `json:"from,omitempty"` // Set by the server to the sender's client ID
+	To      string           `json:"to,omitempty"`   // Recipient of an offer, answer or candidate
+	Data    json.RawMessage  `json:"data,omitempty"` // Session description or ICE candidate, passed through untouched
+	Status  *VoiceStatus     `json:"status,omitempty"`
+	Peers   []VoicePeerState `json:"peers,omitempty"`
+	Message string           `json:"message,omitempty"` // Only set for errors
+}
+
+// Voice state of a lobby client. Connected and ServerMuted are controlled by the server
+type VoiceStatus struct {
+	Connected   bool `json:"connected"`
+	Muted       bool `json:"muted"`
+	Deafened    bool `json:"deafened"`
+	Speaking    bool `json:"speaking"`
+	ServerMuted bool `json:"server_muted"` // Set by the lobby leader, clients should not play this peer's audio
+}
+
+type VoicePeerState struct {
+	ID string `json:"id"`
+	VoiceStatus
+}
+
+type VoicePeer struct {
+	VoicePeerState // Protected by the lobby's mutex
+
+	conn Channel
+}
+
+func (p *VoicePeer) send(m *VoiceMessage) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		slog.Error("marshal voice message", "err", err)
+		return
+	}
+
+	p.conn.Send(data)
+}
+
+func (p *VoicePeer) sendError(err error) {
+	p.send(&VoiceMessage{
+		Type:    VoiceMessageError,
+		Message: err.Error(),
+	})
+}
+
+// Messages between peers go through the bus, but peer lists only cover the peers connected to
+// this node. Every voice connection of a lobby is routed to the node that owns it, so that is all of them
+type VoiceLobby struct {
+	id          string
+	bus         PubSub
+	mu          sync.RWMutex // Protect peers and unsubscribe
+	peers       map[string]*VoicePeer
+	unsubscribe func() // Stops delivering messages published to the lobby
+}
+
+// A message on its way to the peers of a lobby, which may be connected to any node
+type voiceDelivery struct {
+	To      string        `json:"to,omitempty"`      // Only this peer, or everyone if empty
+	Except  string        `json:"except,omitempty"`  // Everyone but this peer
+	Message *VoiceMessage `json:"message,omitempty"` // Nothing is sent if empty
+	Close   bool          `json:"close,omitempty"`   // Disconnect the recipients once the message is sent
+}
+
 var voiceUpgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+	CheckOrigin:     checkOrigin,
 }
 
-// map of voiceLobbies to clients to connections
-// var voiceLobbies = map[string]map[string]*websocket.Conn{}
-var voiceLobbies sync.Map
+type VoiceServer struct {
+	lobbies *LobbyManager
+}
 
-func handlevoiceClose(lobbyID string, clientID string) {
-	if lobbyAny, ok := voiceLobbies.Load(lobbyID); ok {
-		lobby := lobbyAny.(*sync.Map)
-		if clientAny, ok := lobby.Load(clientID); ok {
-			client := clientAny.(*websocket.Conn)
-			client.Close()
-			lobby.Delete(clientID)
-		}
+func NewVoiceServer(lobbies *LobbyManager) *VoiceServer {
+	return &VoiceServer{
+		lobbies: lobbies,
+	}
+}
 
-		// Remove empty lobbies from map
-		empty := true
-		lobby.Range(func(_, _ interface{}) bool {
-			empty = false
-			return false
-		})
+// Every lobby has its own voice chat, which lives and dies with it
+func newVoiceLobby(lobbyID string, bus PubSub) *VoiceLobby {
+	return &VoiceLobby{
+		id:    lobbyID,
+		bus:   bus,
+		peers: make(map[string]*VoicePeer),
+	}
+}
 
-		if empty {
-			voiceLobbies.Delete(lobbyID)
-		}
+func voiceTopic(lobbyID string) string {
+	return "voice." + lobbyID
+}
+
+// Starts delivering messages published to the lobby to the peers connected here
+func (vl *VoiceLobby) subscribe() {
+	vl.mu.Lock()
+	defer vl.mu.Unlock()
+	vl.unsubscribe = vl.bus.Subscribe(voiceTopic(vl.id), vl.deliver)
+}
+
+func (vl *VoiceLobby) publish(d *voiceDelivery) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		slog.Error("marshal voice message", "err", err)
+		return
+	}
+
+	if err := vl.bus.Publish(voiceTopic(vl.id), data); err != nil {
+		slog.Error("publish voice message", "lobby", vl.id, "err", err)
 	}
 }
 
-func handleVoiceWs(w http.ResponseWriter, r *http.Request) {
-	defer func() {
-		// Just to make sure the server won't crash
-		if r := recover(); r != nil {
-			log.Println("recovered error in handleWs")
-			if err, ok := r.(error); ok {
-				log.Println(err.Error())
-			} else {
-				log.Println(r)
+// Sends a published message to its recipients connected to this node
+func (vl *VoiceLobby) deliver(data []byte) {
+	d := &voiceDelivery{}
+	if err := json.Unmarshal(data, d); err != nil {
+		slog.Error("unmarshal voice message", "lobby", vl.id, "err", err)
+		return
+	}
+
+	closing := []*VoicePeer{}
+	vl.mu.RLock()
+	for id, p := range vl.peers {
+		if (d.To == "" || d.To == id) && d.Except != id {
+			if d.Message != nil {
+				p.send(d.Message)
+			}
+
+			if d.Close {
+				closing = append(closing, p)
 			}
 		}
-	}()
+	}
+	vl.mu.RUnlock()
 
-	var lobbyID, clientID string
+	for _, p := range closing {
+		vl.leave(p)
+	}
+}
 
-	conn, err := voiceUpgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println("Upgrade:", err)
+// Adds the peer to the lobby, sends it everyone already connected and tells them about the new peer
+func (vl *VoiceLobby) join(peer *VoicePeer) {
+	vl.mu.Lock()
+	// If a user joins more than once, remove the previous instance
+	if prev, ok := vl.peers[peer.ID]; ok {
+		prev.conn.Close()
+	}
+
+	states := []VoicePeerState{}
+	for id, p := range vl.peers {
+		if id != peer.ID {
+			states = append(states, p.VoicePeerState)
+		}
+	}
+
+	vl.peers[peer.ID] = peer
+	vl.mu.Unlock()
+
+	peer.send(&VoiceMessage{Type: VoiceMessagePeers, Peers: states})
+	vl.broadcast(peer.ID, &VoiceMessage{Type: VoiceMessageJoin, From: peer.ID})
+}
+
+// Removes the peer from the lobby and tells everyone else it left
+func (vl *VoiceLobby) leave(peer *VoicePeer) {
+	vl.mu.Lock()
+	// The peer may have already been replaced by a newer connection
+	if vl.peers[peer.ID] != peer {
+		vl.mu.Unlock()
+		peer.conn.Close()
 		return
 	}
 
-	conn.SetCloseHandler(func(code int, text string) error {
-		handlevoiceClose(lobbyID, clientID)
+	delete(vl.peers, peer.ID)
+	vl.mu.Unlock()
+	peer.conn.Close()
+
+	vl.broadcast(peer.ID, &VoiceMessage{Type: VoiceMessageLeave, From: peer.ID})
+}
+
+// Sends a message to everyone in the lobby except the sender
+func (vl *VoiceLobby) broadcast(sender string, m *VoiceMessage) {
+	vl.publish(&voiceDelivery{Except: sender, Message: m})
+}
+
+// Sends a message to a single peer, if they are connected
+func (vl *VoiceLobby) sendTo(recipient string, m *VoiceMessage) {
+	vl.publish(&voiceDelivery{To: recipient, Message: m})
+}
+
+// Updates a peer's status and tells everyone in the lobby, including the peer itself
+func (vl *VoiceLobby) setStatus(clientID string, status VoiceStatus) {
+	vl.mu.Lock()
+	if p, ok := vl.peers[clientID]; ok {
+		p.VoiceStatus = status
+	}
+	vl.mu.Unlock()
+
+	vl.broadcast("", &VoiceMessage{Type: VoiceMessageStatus, From: clientID, Status: &status})
+}
+
+func (vl *VoiceLobby) peer(clientID string) *VoicePeer {
+	vl.mu.RLock()
+	defer vl.mu.RUnlock()
+	return vl.peers[clientID]
+}
+
+// Disconnects the client from the lobby's voice chat, on whichever node they are connected to
+func (vl *VoiceLobby) disconnect(clientID string) {
+	vl.publish(&voiceDelivery{To: clientID, Close: true})
+}
+
+// Applies the update to the client's voice status in the lobby state and syncs it. Returns
+// the updated status, or false if the client is no longer in the lobby
+func (lm *LobbyManager) updateVoiceStatus(lobbyID string, clientID string, update func(v *VoiceStatus)) (VoiceStatus, bool) {
+	entry, ok := lm.Lobbies.Load(lobbyID)
+	if !ok {
+		return VoiceStatus{}, false
+	}
+
+	lobby := entry.(*Lobby)
+	var status VoiceStatus
+	err := lobby.call(func() error {
+		lc, ok := lobby.Clients[clientID]
+		if !ok {
+			return errors.New("client is not in the lobby")
+		}
+
+		update(&lc.Voice)
+		// Nobody can be heard while muted
+		if lc.Voice.Muted || lc.Voice.ServerMuted {
+			lc.Voice.Speaking = false
+		}
+
+		lobby.Sync()
+		status = lc.Voice
 		return nil
 	})
 
-	messageType, msg, err := conn.ReadMessage()
+	return status, err == nil
+}
+
+// Stops delivering messages to the lobby and closes every voice connection held here
+func (vl *VoiceLobby) close() {
+	vl.mu.Lock()
+	defer vl.mu.Unlock()
+
+	if vl.unsubscribe != nil {
+		vl.unsubscribe()
+		vl.unsubscribe = nil
+	}
+
+	for id, p := range vl.peers {
+		p.conn.Close()
+		delete(vl.peers, id)
+	}
+}
+
+// Checks that a message sent by a client is well formed
+func (vl *VoiceLobby) validate(sender string, m *VoiceMessage) error {
+	switch m.Type {
+	case VoiceMessageOffer, VoiceMessageAnswer:
+		desc := struct {
+			Type string `json:"type"`
+			SDP  string `json:"sdp"`
+		}{}
+		if err := json.Unmarshal(m.Data, &desc); err != nil || desc.SDP == "" {
+			return errors.New("invalid session description")
+		}
+
+		if desc.Type != string(m.Type) {
+			return errors.New("session description type does not match message type")
+		}
+
+	case VoiceMessageCandidate:
+		candidate := struct {
+			Candidate *string `json:"candidate"`
+		}{}
+		if err := json.Unmarshal(m.Data, &candidate); err != nil || candidate.Candidate == nil {
+			return errors.New("invalid ICE candidate")
+		}
+
+	case VoiceMessageStatus:
+		if m.Status == nil {
+			return errors.New("status not provided")
+		}
+		return nil
+
+	case VoiceMessageLeave:
+		return nil
+
+	default:
+		return errors.New("unknown message type")
+	}
+
+	if m.To == sender {
+		return errors.New("cannot signal yourself")
+	}
+
+	return nil
+}
+
+// A client's connection to their lobby's voice chat
+type voiceSession struct {
+	server   *VoiceServer
+	lobby    *VoiceLobby
+	peer     *VoicePeer
+	lobbyID  string
+	clientID string
+	closed   sync.Once
+}
+
+// Attaches an authenticated connection to the lobby's voice chat. Returns nil if the
+// client is no longer in the lobby. The peer joins on the lobby's goroutine, where clients
+// are removed and lobbies deleted, so it can't slip past revokeKeys or the voice chat being closed
+func (s *VoiceServer) open(conn Channel, lobbyID string, clientID string) *voiceSession {
+	entry, ok := s.lobbies.Lobbies.Load(lobbyID)
+	if !ok {
+		return nil
+	}
+
+	lobby := entry.(*Lobby)
+	vs := &voiceSession{
+		server:   s,
+		lobby:    lobby.voice,
+		lobbyID:  lobbyID,
+		clientID: clientID,
+	}
+
+	err := lobby.call(func() error {
+		lc, ok := lobby.Clients[clientID]
+		if !ok {
+			return errors.New("client is not in the lobby")
+		}
+
+		// Server mutes are kept across reconnects
+		lc.Voice = VoiceStatus{Connected: true, ServerMuted: lc.Voice.ServerMuted}
+		lobby.Sync()
+
+		vs.peer = &VoicePeer{
+			VoicePeerState: VoicePeerState{ID: clientID, VoiceStatus: lc.Voice},
+			conn:           conn,
+		}
+		vs.lobby.join(vs.peer)
+		return nil
+	})
 	if err != nil {
-		return
+		return nil
 	}
 
-	if messageType == websocket.TextMessage {
-		token, err := jwt.Parse(string(msg), func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
+	return vs
+}
+
+func (vs *voiceSession) close() {
+	vs.closed.Do(func() {
+		vs.lobby.leave(vs.peer)
+
+		// Only mark the client as disconnected if they have not connected again in the meantime
+		if vs.lobby.peer(vs.clientID) == nil {
+			vs.server.lobbies.updateVoiceStatus(vs.lobbyID, vs.clientID, func(v *VoiceStatus) {
+				*v = VoiceStatus{ServerMuted: v.ServerMuted}
+			})
+		}
+	})
+}
+
+// Handles a single message sent by the client. Returns false once the session has been closed
+func (vs *voiceSession) handle(msg []byte) bool {
+	if !vs.server.lobbies.IsMember(vs.lobbyID, vs.clientID) {
+		vs.close()
+		return false
+	}
+
+	m := &VoiceMessage{}
+	if err := json.Unmarshal(msg, m); err != nil {
+		vs.peer.sendError(errors.New("invalid message"))
+		return true
+	}
+
+	if err := vs.lobby.validate(vs.clientID, m); err != nil {
+		vs.peer.sendError(err)
+		return true
+	}
+
+	slog.Debug("voice message", "lobby", vs.lobbyID, "client", vs.clientID, "type", m.Type, "to", m.To, "data", redact(string(m.Data)))
+
+	switch m.Type {
+	case VoiceMessageLeave:
+		vs.close()
+		return false
 
-			return sharedKey, nil
+	case VoiceMessageStatus:
+		status, ok := vs.server.lobbies.updateVoiceStatus(vs.lobbyID, vs.clientID, func(v *VoiceStatus) {
+			v.Muted = m.Status.Muted
+			v.Deafened = m.Status.Deafened
+			v.Speaking = m.Status.Speaking
 		})
-		if err != nil {
-			log.Println(err)
-			return
+		if ok {
+			vs.lobby.setStatus(vs.clientID, status)
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			clientID = claims["client_id"].(string)
-			lobbyID = claims["lobby_id"].(string)
-		} else {
-			log.Println(err)
-			return
+	default:
+		// The recipient may not have joined voice yet, so only their membership is checked
+		if !vs.server.lobbies.IsMember(vs.lobbyID, m.To) {
+			vs.peer.sendError(errors.New("recipient is not in the lobby"))
+			return true
 		}
+
+		vs.lobby.sendTo(m.To, &VoiceMessage{Type: m.Type, From: vs.clientID, Data: m.Data})
 	}
 
-	lobbyAny, _ := voiceLobbies.LoadOrStore(lobbyID, &sync.Map{})
-	lobby := lobbyAny.(*sync.Map)
+	return true
+}
 
-	// If a user joins more than once, remove the previous instance
-	if _, ok := lobby.Load(clientID); ok {
-		handlevoiceClose(lobbyID, clientID)
+func (s *VoiceServer) handleVoiceWs(w http.ResponseWriter, r *http.Request) {
+	defer logRecover("handleVoiceWs")
+
+	ip, ok := acquireConn(w, r)
+	if !ok {
+		return
 	}
+	defer connLimiter.Release(ip)
 
-	lobby.Range(func(_, clientAny interface{}) bool {
-		client := clientAny.(*websocket.Conn)
-		client.WriteMessage(websocket.TextMessage, []byte(clientID))
+	conn, err := voiceUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("upgrade failed", "socket", "voice", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(voiceReadLimit)
 
-		return true
+	// Signaling goes quiet once peers are connected, so only dead connections are closed
+	keepAlive := startKeepAlive(conn, 0)
+	defer keepAlive.stop()
+
+	metricConnectedClients.WithLabelValues("voice").Inc()
+	defer metricConnectedClients.WithLabelValues("voice").Dec()
+
+	messageType, msg, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+
+	if messageType != websocket.TextMessage {
+		return
+	}
+
+	claims, err := parseKey(string(msg))
+	if err != nil {
+		slog.Info("invalid key", "socket", "voice", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+
+	session := s.open(newWsChannel(conn), claims.LobbyID, claims.ClientID)
+	if session == nil {
+		return
+	}
+
+	conn.SetCloseHandler(func(code int, text string) error {
+		session.close()
+		return nil
 	})
 
-	lobby.Store(clientID, conn)
+	// Close the connection once the key expires. The client can reconnect with a refreshed key
+	expiry := time.AfterFunc(time.Until(claims.ExpiresAt.Time), session.close)
+	defer expiry.Stop()
 
 	for {
 		messageType, msg, err := conn.ReadMessage()
 		if err != nil {
-			handlevoiceClose(lobbyID, clientID)
+			session.close()
 			return
 		}
 
-		if messageType == websocket.TextMessage {
-			log.Printf("[voice] %s->%s: %s\n", clientID, lobbyID, msg)
-
-			if len(msg) < 40 {
-				continue
-			}
-			recipient := string(msg[:len(clientID)])
-			if recipient == clientID {
-				continue
-			}
-
-			// Replace recipient ID with sender ID
-			copy(msg, clientID)
-
-			if clientAny, ok := lobby.Load(recipient); ok {
-				receiver := clientAny.(*websocket.Conn)
-				receiver.WriteMessage(websocket.TextMessage, msg)
-			}
+		if messageType == websocket.TextMessage && !session.handle(msg) {
+			return
 		}
 	}
 }
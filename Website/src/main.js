@@ -10,6 +10,13 @@ import GameTheMind from './components/games/GameTheMind.vue';
 import GameWar from './components/games/GameWar.vue';
 import GameUno from './components/games/GameUno.vue';
 import GameHalliGalli from './components/games/GameHalliGalli.vue';
+import GameHearts from './components/games/GameHearts.vue';
+import GameSpades from './components/games/GameSpades.vue';
+import GameHoldem from './components/games/GameHoldem.vue';
+import GameBlackjack from './components/games/GameBlackjack.vue';
+import GameCrazyEights from './components/games/GameCrazyEights.vue';
+import GameGoFish from './components/games/GameGoFish.vue';
+import GameCheat from './components/games/GameCheat.vue';
 
 import './assets/style.css';
 
@@ -25,6 +32,13 @@ app.component('the_mind', GameTheMind);
 app.component('war', GameWar);
 app.component('uno', GameUno);
 app.component('halli_galli', GameHalliGalli);
+app.component('hearts', GameHearts);
+app.component('spades', GameSpades);
+app.component('holdem', GameHoldem);
+app.component('blackjack', GameBlackjack);
+app.component('crazy_eights', GameCrazyEights);
+app.component('go_fish', GameGoFish);
+app.component('cheat', GameCheat);
 
 
 app.mount('#app');
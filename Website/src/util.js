@@ -1,6 +1,23 @@
+import Card from './Card';
+
 // Prepares array of ordered id's to be rendered
 export const reorder = ps => {
 	if (ps.length <= 2) return ps;
 	if (ps.length === 3) return [ps[0], ps[2], ps[1]];
 	if (ps.length === 4) return [ps[0], ps[2], ps[1], ps[3]];
-};
\ No newline at end of file
+};
+
+const suitSymbols = { C: '♣', D: '♦', H: '♥', S: '♠' };
+
+// Turns the short code of a standard card, like "QH" or "TC", into what is shown on it
+export const cardLabel = code => {
+	if (code === 'BJ' || code === 'RJ') return '🃏';
+	const rank = code[0] === 'T' ? '10' : code[0];
+	return rank + suitSymbols[code[1]];
+};
+
+export const isRedCard = code => code === 'RJ' || code[1] === 'D' || code[1] === 'H';
+
+// A clickable card showing the short code of a standard card
+export const standardCard = (code, onclick = null) =>
+	new Card(cardLabel(code), false, onclick, isRedCard(code) ? { color: 'var(--card-stripe-color)' } : {});